@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/accrualsystem"
+	conf "github.com/alphaonly/gomartv2/internal/configuration"
+)
+
+func main() {
+
+	configuration := conf.NewAccrualConf(conf.UpdateACFromEnvironment, conf.UpdateACFromFlags)
+
+	handlers := &accrualsystem.Handlers{
+		Service: accrualsystem.NewService(time.Duration(configuration.ProcessingTime)),
+		Limiter: accrualsystem.NewRateLimiter(configuration.RateLimit),
+	}
+	httpServer := &http.Server{
+		Addr:    configuration.RunAddress,
+		Handler: handlers.NewRouter(),
+	}
+
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	osSignal := make(chan os.Signal, 1)
+	signal.Notify(osSignal, os.Interrupt)
+	<-osSignal
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Accrual system shutdown")
+}
@@ -2,42 +2,103 @@ package main
 
 import (
 	"context"
+	"flag"
 	conf "github.com/alphaonly/gomartv2/internal/configuration"
 	"github.com/alphaonly/gomartv2/internal/server"
 	"github.com/alphaonly/gomartv2/internal/server/accrual"
+	"github.com/alphaonly/gomartv2/internal/server/auth"
 	"github.com/alphaonly/gomartv2/internal/server/handlers"
+	"github.com/alphaonly/gomartv2/internal/server/hub"
+	"github.com/alphaonly/gomartv2/internal/server/outbox"
 	db "github.com/alphaonly/gomartv2/internal/server/storage/implementations/dbstorage"
+	mem "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
 	stor "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+	"github.com/alphaonly/gomartv2/internal/server/webhook"
 	"log"
+	"strings"
+	"time"
 )
 
 func main() {
 
 	configuration := conf.NewServerConf(conf.UpdateSCFromEnvironment, conf.UpdateSCFromFlags)
 
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(context.Background(), configuration, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var (
 		externalStorage stor.Storage
 		internalStorage stor.Storage
 	)
 
 	externalStorage = nil
-	internalStorage = db.NewDBStorage(context.Background(), configuration.DatabaseURI)
+	//data is kept in memory on explicit request only, otherwise a missing DATABASE_URI would lose every balance on restart
+	switch {
+	case configuration.DatabaseURI != "":
+		internalStorage = db.NewDBStorage(context.Background(), configuration.DatabaseURI)
+	case configuration.MemoryStorage:
+		log.Println("WARNING: storage is in memory (MEMORY_STORAGE), all users, orders and balances are lost on restart")
+		internalStorage = mem.NewMemStorage()
+	default:
+		log.Fatal("DATABASE_URI is empty: set it or opt in to in-memory storage with MEMORY_STORAGE=true (flag -m)")
+	}
+
+	tiers, err := configuration.Tiers()
+	if err != nil {
+		log.Fatal(err)
+	}
 
+	orderUpdates := hub.New(hub.DefaultBacklog, hub.DefaultBuffer)
+	webhooks := webhook.NewDispatcher(internalStorage, configuration.Key)
 	handlers := &handlers.Handlers{
 		Storage:       internalStorage,
 		Conf:          conf.ServerConfiguration{DatabaseURI: configuration.DatabaseURI},
-		EntityHandler: handlers.NewEntityHandler(internalStorage),
+		EntityHandler: handlers.NewEntityHandler(internalStorage, auth.NewTokens(configuration.Key, time.Duration(configuration.TokenTTL))),
+		Hub:           orderUpdates,
+		Webhooks:      webhooks,
 	}
-	accrualChecker := accrual.NewChecker(configuration.AccrualSystemAddress, configuration.AccrualTime, internalStorage)
+	handlers.EntityHandler.Expiry = configuration.ExpiryPolicy()
+	handlers.EntityHandler.Tiers = tiers
+	accrualChecker := accrual.NewChecker(configuration.AccrualSystemAddress, configuration.AccrualTime, configuration.AccrualWorkers, internalStorage, orderUpdates, configuration.ExpiryPolicy(), tiers)
+	outboxRelay := outbox.NewRelay(internalStorage, outboxSinks(configuration, webhooks)...)
 
-	gmServer := server.New(configuration, externalStorage, handlers, accrualChecker)
+	gmServer := server.New(configuration, externalStorage, handlers, accrualChecker, outboxRelay)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err := gmServer.Run(ctx)
+	err = gmServer.Run(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 }
+
+// outboxSinks creates the sinks listed in OUTBOX_SINKS
+func outboxSinks(configuration *conf.ServerConfiguration, webhooks *webhook.Dispatcher) (sinks []outbox.Sink) {
+	for _, name := range strings.Split(configuration.OutboxSinks, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "log":
+			sinks = append(sinks, outbox.LogSink{})
+		case "webhook":
+			sinks = append(sinks, webhooks)
+		case "file":
+			if configuration.OutboxFile == "" {
+				log.Fatal("OUTBOX_FILE is required by the file outbox sink")
+			}
+			fileSink, err := outbox.NewFileSink(configuration.OutboxFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sinks = append(sinks, fileSink)
+		default:
+			log.Fatalf("unknown outbox sink %q", name)
+		}
+	}
+	return sinks
+}
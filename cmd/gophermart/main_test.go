@@ -2,12 +2,12 @@ package main_test
 
 import (
 	"context"
-	"log"
 	"testing"
 	"time"
 
 	"github.com/alphaonly/gomartv2/internal/server/accrual"
 	db "github.com/alphaonly/gomartv2/internal/server/storage/implementations/dbstorage"
+	mem "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
 	stor "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
@@ -47,14 +47,14 @@ func TestRun(t *testing.T) {
 
 			var storage stor.Storage
 			if sc.DatabaseURI == "" {
-				log.Fatal("no database url")
+				storage = mem.NewMemStorage()
 			} else {
 				storage = db.NewDBStorage(ctx, sc.DatabaseURI)
 			}
 			handlers := &handlers.Handlers{}
 
-			ac := accrual.NewChecker(sc.AccrualSystemAddress, sc.AccrualTime, storage)
-			server := server.New(sc, storage, handlers, ac)
+			ac := accrual.NewChecker(sc.AccrualSystemAddress, sc.AccrualTime, sc.AccrualWorkers, storage, nil, sc.ExpiryPolicy(), nil)
+			server := server.New(sc, storage, handlers, ac, nil)
 
 			go func() {
 				err := server.Run(ctx)
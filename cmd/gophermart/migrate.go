@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	conf "github.com/alphaonly/gomartv2/internal/configuration"
+	db "github.com/alphaonly/gomartv2/internal/server/storage/implementations/dbstorage"
+)
+
+const migrateUsage = "usage: gophermart [flags] migrate up | down [steps] | version"
+
+// migrate runs "gophermart migrate" subcommand:
+//
+//	up           applies all pending migrations
+//	down [steps] reverts the last steps migrations, one by default
+//	version      prints the current schema version
+func migrate(ctx context.Context, configuration *conf.ServerConfiguration, args []string) error {
+	if configuration.DatabaseURI == "" {
+		return errors.New("DATABASE_URI is empty, there is no schema to migrate")
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := db.NewMigrator(ctx, configuration.DatabaseURI)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("%v migrations applied, schema version %v", applied, m.Latest())
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("steps must be a positive number, got %v", args[1])
+			}
+		}
+		reverted, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("%v migrations reverted, schema version %v", reverted, version)
+	case "version":
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %v, latest %v\n", version, m.Latest())
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package accrualsystem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/theplant/luhn"
+)
+
+type Handlers struct {
+	Service *Service
+	Limiter *RateLimiter
+}
+
+func (h *Handlers) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/", func(r chi.Router) {
+		r.Post("/api/goods", h.HandlePostGoods)
+		r.Post("/api/orders", h.HandlePostOrders)
+		r.Get("/api/orders/{number}", h.Limiter.Handler(http.HandlerFunc(h.HandleGetOrder)))
+	})
+
+	return r
+}
+
+func (h *Handlers) HandlePostGoods(w http.ResponseWriter, r *http.Request) {
+	log.Println("HandlePostGoods invoked")
+	var reward Reward
+	if err := readJSON(r, &reward); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := h.Service.RegisterReward(reward)
+	switch {
+	case errors.Is(err, ErrMatchExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (h *Handlers) HandlePostOrders(w http.ResponseWriter, r *http.Request) {
+	log.Println("HandlePostOrders invoked")
+	var order Order
+	if err := readJSON(r, &order); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validNumber(order.Order) {
+		http.Error(w, fmt.Sprintf("order number %v is invalid", order.Order), http.StatusBadRequest)
+		return
+	}
+	err := h.Service.RegisterOrder(order)
+	switch {
+	case errors.Is(err, ErrOrderExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (h *Handlers) HandleGetOrder(w http.ResponseWriter, r *http.Request) {
+	log.Println("HandleGetOrder invoked")
+	response, err := h.Service.GetOrder(chi.URLParam(r, "number"))
+	if errors.Is(err, ErrOrderUnknown) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Printf("order accrual response write error: %v", err)
+	}
+}
+
+func readJSON(r *http.Request, v any) error {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+func validNumber(number string) bool {
+	n, err := strconv.Atoi(number)
+	return err == nil && n > 0 && luhn.Valid(n)
+}
+
+// RateLimiter allows limit requests per minute window and answers 429 with Retry-After above it,
+// zero limit means unlimited
+type RateLimiter struct {
+	mu          sync.Mutex
+	limit       int
+	count       int
+	windowStart time.Time
+}
+
+func NewRateLimiter(limit int) *RateLimiter {
+	return &RateLimiter{limit: limit}
+}
+
+func (l *RateLimiter) Allow(now time.Time) (ok bool, retryAfter time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.windowStart) >= time.Minute {
+		l.windowStart = now
+		l.count = 0
+	}
+	if l.count >= l.limit {
+		return false, l.windowStart.Add(time.Minute).Sub(now)
+	}
+	l.count++
+	return true, 0
+}
+
+func (l *RateLimiter) Handler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := l.Allow(time.Now())
+		if !ok {
+			seconds := int((retryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, fmt.Sprintf("No more than %v requests per minute allowed", l.limit), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,153 @@
+package accrualsystem
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+)
+
+//Mock of accrual system: orders are registered with their goods and accrual is calculated
+//asynchronously according to reward rules matched by goods' description
+
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrMatchExists  = errors.New("reward for the match is already registered")
+	ErrOrderExists  = errors.New("order is already registered")
+	ErrOrderUnknown = errors.New("order is not registered")
+)
+
+type Reward struct {
+	Match      string        `json:"match"`
+	Reward     schema.Points `json:"reward"`
+	RewardType string        `json:"reward_type"`
+}
+
+type Good struct {
+	Description string        `json:"description"`
+	Price       schema.Points `json:"price"`
+}
+
+type Order struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+type Service struct {
+	mu             sync.RWMutex
+	rewards        []Reward
+	orders         map[string]*schema.OrderAccrualResponse
+	goods          map[string][]Good
+	processingTime time.Duration
+}
+
+func NewService(processingTime time.Duration) *Service {
+	return &Service{
+		orders:         make(map[string]*schema.OrderAccrualResponse),
+		goods:          make(map[string][]Good),
+		processingTime: processingTime,
+	}
+}
+
+func (s *Service) RegisterReward(r Reward) (err error) {
+	if r.Match == "" || r.Reward <= 0 || (r.RewardType != RewardPercent && r.RewardType != RewardPoints) {
+		return ErrBadRequest
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, registered := range s.rewards {
+		if registered.Match == r.Match {
+			return ErrMatchExists
+		}
+	}
+	s.rewards = append(s.rewards, r)
+	return nil
+}
+
+func (s *Service) RegisterOrder(o Order) (err error) {
+	if o.Order == "" {
+		return ErrBadRequest
+	}
+	for _, g := range o.Goods {
+		if g.Price < 0 {
+			return ErrBadRequest
+		}
+	}
+	s.mu.Lock()
+	if _, ok := s.orders[o.Order]; ok {
+		s.mu.Unlock()
+		return ErrOrderExists
+	}
+	s.orders[o.Order] = &schema.OrderAccrualResponse{Order: o.Order, Status: schema.AccrualRegistered}
+	s.goods[o.Order] = o.Goods
+	s.mu.Unlock()
+
+	//calculation is asynchronous: REGISTERED -> PROCESSING -> PROCESSED or INVALID
+	time.AfterFunc(s.processingTime/2, func() {
+		s.setStatus(o.Order, schema.AccrualProcessing, 0)
+		time.AfterFunc(s.processingTime/2, func() { s.process(o.Order) })
+	})
+	return nil
+}
+
+func (s *Service) GetOrder(number string) (response schema.OrderAccrualResponse, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.orders[number]
+	if !ok {
+		return response, ErrOrderUnknown
+	}
+	return *o, nil
+}
+
+func (s *Service) process(number string) {
+	accrual, matched := s.calculate(number)
+	if !matched {
+		s.setStatus(number, schema.AccrualInvalid, 0)
+		return
+	}
+	s.setStatus(number, schema.AccrualProcessed, accrual)
+}
+
+func (s *Service) setStatus(number string, status string, accrual schema.Points) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.orders[number]
+	o.Status = status
+	o.Accrual = accrual
+	log.Printf("accrual system: order %v %v %v", number, status, accrual)
+}
+
+// calculate sums rewards of goods, each good is rewarded by the first matching rule
+func (s *Service) calculate(number string) (accrual schema.Points, matched bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, g := range s.goods[number] {
+		for _, r := range s.rewards {
+			if !strings.Contains(g.Description, r.Match) {
+				continue
+			}
+			matched = true
+			accrual += r.For(g)
+			break
+		}
+	}
+	return accrual, matched
+}
+
+// For returns reward for the good, percent reward is rounded half up to hundredths
+func (r Reward) For(g Good) schema.Points {
+	if r.RewardType == RewardPoints {
+		return r.Reward
+	}
+	//price in hundredths * percent in hundredths / (100% * 100)
+	return schema.Points((int64(g.Price)*int64(r.Reward) + 5000) / 10000)
+}
@@ -0,0 +1,60 @@
+package accrualsystem
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceAccrual(t *testing.T) {
+
+	s := NewService(20 * time.Millisecond)
+	assert.NoError(t, s.RegisterReward(Reward{Match: "Bork", Reward: 1000, RewardType: RewardPercent}))
+	assert.NoError(t, s.RegisterReward(Reward{Match: "Tefal", Reward: 5000, RewardType: RewardPoints}))
+	assert.True(t, errors.Is(s.RegisterReward(Reward{Match: "Bork", Reward: 100, RewardType: RewardPoints}), ErrMatchExists))
+
+	tests := []struct {
+		name        string
+		order       Order
+		wantStatus  string
+		wantAccrual schema.Points
+	}{
+		{
+			name: "test#1 - Positive: percent and fixed rewards",
+			order: Order{Order: "12345678903", Goods: []Good{
+				{Description: "Чайник Bork", Price: 700055},
+				{Description: "Сковорода Tefal", Price: 100000},
+			}},
+			wantStatus:  schema.AccrualProcessed,
+			wantAccrual: 75006,
+		},
+		{
+			name:       "test#2 - Negative: no goods matched",
+			order:      Order{Order: "9278923470", Goods: []Good{{Description: "Стол", Price: 10000}}},
+			wantStatus: schema.AccrualInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, s.RegisterOrder(tt.order))
+			assert.True(t, errors.Is(s.RegisterOrder(tt.order), ErrOrderExists))
+
+			got, err := s.GetOrder(tt.order.Order)
+			assert.NoError(t, err)
+			assert.Equal(t, schema.AccrualRegistered, got.Status)
+
+			assert.Eventually(t, func() bool {
+				got, _ = s.GetOrder(tt.order.Order)
+				return got.Status == tt.wantStatus
+			}, time.Second, 5*time.Millisecond)
+			assert.Equal(t, tt.wantAccrual, got.Accrual)
+		})
+	}
+
+	_, err := s.GetOrder("1")
+	assert.True(t, errors.Is(err, ErrOrderUnknown))
+}
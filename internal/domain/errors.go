@@ -0,0 +1,33 @@
+// Package domain holds errors of gophermart business rules.
+// Logic wraps them with details by fmt.Errorf("%w: ...") and callers check them by errors.Is,
+// handlers turn them into HTTP responses in one place.
+package domain
+
+import "errors"
+
+var (
+	ErrEmptyCredentials   = errors.New("login or password is empty")
+	ErrUserExists         = errors.New("login is occupied")
+	ErrInvalidCredentials = errors.New("login or password is unknown")
+	ErrUnauthorized       = errors.New("user is not authenticated")
+
+	ErrInvalidOrderNumber = errors.New("order number is not a positive number")
+	ErrInvalidLuhn        = errors.New("order number does not pass Luhn check")
+	ErrOrderExists        = errors.New("order is already uploaded by the user")
+	ErrOrderOwnedByOther  = errors.New("order is already uploaded by another user")
+	ErrUnprocessableOrder = errors.New("order number cannot be processed")
+	ErrOrderNotFound      = errors.New("order is not found")
+
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrWithdrawalExists  = errors.New("withdrawal for the order is already made")
+
+	ErrIdempotencyKeyReused = errors.New("idempotency key is already used for another request")
+
+	ErrInvalidWebhookURL = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookNotPublic  = errors.New("webhook url must point to a public address")
+	ErrWebhookExists     = errors.New("webhook with the url already exists")
+	ErrWebhookNotFound   = errors.New("webhook is not found")
+
+	ErrBadRequest = errors.New("bad request")
+)
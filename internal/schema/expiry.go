@@ -0,0 +1,38 @@
+package schema
+
+import "time"
+
+// ExpiryPolicy tells when accrued points expire
+type ExpiryPolicy struct {
+	//Months after crediting the points expire, zero means they never expire
+	Months int
+	//Grace is how long expired points stay spendable before they are written off
+	Grace time.Duration
+	//Soon is how far ahead the balance warns about expiring points
+	Soon time.Duration
+}
+
+// Expires is the expiry of points credited at credited, zero if they never expire
+func (p ExpiryPolicy) Expires(credited time.Time) CreatedTime {
+	if p.Months <= 0 {
+		return CreatedTime{}
+	}
+	return CreatedTime(credited.AddDate(0, p.Months, 0))
+}
+
+// Due is the latest expiry of the points to write off at now
+func (p ExpiryPolicy) Due(now time.Time) time.Time {
+	return now.Add(-p.Grace)
+}
+
+// SoonBefore is the expiry the points expiring soon at now are before
+func (p ExpiryPolicy) SoonBefore(now time.Time) time.Time {
+	return now.Add(p.Soon)
+}
+
+// ExpiringPoints is what is left of the credit for the order and when it expires
+type ExpiringPoints struct {
+	Order   int64       `json:"order,string"`
+	Amount  Points      `json:"amount"`
+	Expires CreatedTime `json:"expires_at"`
+}
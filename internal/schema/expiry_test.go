@@ -0,0 +1,29 @@
+package schema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryPolicy(t *testing.T) {
+	credited := time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		policy  ExpiryPolicy
+		expires time.Time
+	}{
+		{name: "test#1 - Positive: never expires", policy: ExpiryPolicy{}, expires: time.Time{}},
+		{name: "test#2 - Positive: a year", policy: ExpiryPolicy{Months: 12}, expires: time.Date(2025, time.January, 31, 12, 0, 0, 0, time.UTC)},
+		{name: "test#3 - Positive: month end is normalized", policy: ExpiryPolicy{Months: 1}, expires: time.Date(2024, time.March, 2, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.expires.Equal(time.Time(tt.policy.Expires(credited))), "got %v", time.Time(tt.policy.Expires(credited)))
+		})
+	}
+
+	policy := ExpiryPolicy{Months: 12, Grace: 24 * time.Hour}
+	assert.Equal(t, credited.Add(-24*time.Hour), policy.Due(credited))
+}
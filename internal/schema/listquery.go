@@ -0,0 +1,98 @@
+package schema
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// ListQuery selects a page of user's orders or withdrawals ordered by time and id
+type ListQuery struct {
+	//Limit is the page size, zero or less means no limit
+	Limit int
+	//After is the position of the last item of the previous page, nil for the first page
+	After *Cursor
+	//Statuses filters orders, empty means any status
+	Statuses []OrderStatus
+	//From is inclusive and To is exclusive, zero time means no bound
+	From time.Time
+	To   time.Time
+	//Descending lists newest first
+	Descending bool
+}
+
+// Cursor is the keyset position of a listed item: its time and its id to break ties
+type Cursor struct {
+	Time time.Time
+	ID   int64
+}
+
+// String is the opaque form of the cursor given to clients
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Time.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.ID, 10)))
+}
+
+func ParseCursor(s string) (c Cursor, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: %v", ErrBadCursor, s)
+	}
+	timeStr, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return c, fmt.Errorf("%w: %v", ErrBadCursor, s)
+	}
+	c.Time, err = time.Parse(time.RFC3339Nano, timeStr)
+	if err != nil {
+		return c, fmt.Errorf("%w: %v", ErrBadCursor, s)
+	}
+	c.ID, err = strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("%w: %v", ErrBadCursor, s)
+	}
+	return c, nil
+}
+
+// Follows tells whether the item at (t, id) comes after the cursor in the query order
+func (q ListQuery) Follows(t time.Time, id int64) bool {
+	if q.After == nil {
+		return true
+	}
+	if q.Descending {
+		return t.Before(q.After.Time) || (t.Equal(q.After.Time) && id < q.After.ID)
+	}
+	return t.After(q.After.Time) || (t.Equal(q.After.Time) && id > q.After.ID)
+}
+
+// InRange tells whether t is within the query date range
+func (q ListQuery) InRange(t time.Time) bool {
+	if !q.From.IsZero() && t.Before(q.From) {
+		return false
+	}
+	return q.To.IsZero() || t.Before(q.To)
+}
+
+// HasStatus tells whether the order status is selected by the query
+func (q ListQuery) HasStatus(status OrderStatus) bool {
+	if len(q.Statuses) == 0 {
+		return true
+	}
+	for _, s := range q.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Less orders items at (ti, idi) and (tj, idj) in the query order
+func (q ListQuery) Less(ti time.Time, idi int64, tj time.Time, idj int64) bool {
+	if !ti.Equal(tj) {
+		return ti.Before(tj) != q.Descending
+	}
+	return (idi < idj) != q.Descending
+}
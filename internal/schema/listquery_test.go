@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	c := Cursor{Time: time.Date(2023, 3, 1, 12, 0, 0, 500, time.FixedZone("MSK", 3*3600)), ID: 79927398713}
+	parsed, err := ParseCursor(c.String())
+	assert.NoError(t, err)
+	assert.True(t, c.Time.Equal(parsed.Time))
+	assert.Equal(t, c.ID, parsed.ID)
+
+	for _, s := range []string{"", "not base64!", "MjAyMw", c.String() + "x"} {
+		_, err := ParseCursor(s)
+		assert.True(t, errors.Is(err, ErrBadCursor), "cursor %q", s)
+	}
+}
+
+func TestListQueryFollows(t *testing.T) {
+	base := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	after := &Cursor{Time: base, ID: 5}
+	tests := []struct {
+		name string
+		q    ListQuery
+		t    time.Time
+		id   int64
+		want bool
+	}{
+		{name: "test#1 - Positive: first page follows nothing", q: ListQuery{}, t: base, id: 1, want: true},
+		{name: "test#2 - Positive: later item ascending", q: ListQuery{After: after}, t: base.Add(time.Second), id: 1, want: true},
+		{name: "test#3 - Positive: same time greater id ascending", q: ListQuery{After: after}, t: base, id: 6, want: true},
+		{name: "test#4 - Negative: cursor item itself", q: ListQuery{After: after}, t: base, id: 5, want: false},
+		{name: "test#5 - Positive: same time less id descending", q: ListQuery{After: after, Descending: true}, t: base, id: 4, want: true},
+		{name: "test#6 - Negative: later item descending", q: ListQuery{After: after, Descending: true}, t: base.Add(time.Second), id: 1, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.q.Follows(tt.t, tt.id))
+		})
+	}
+}
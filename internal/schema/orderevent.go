@@ -0,0 +1,39 @@
+package schema
+
+// OrderEventType is what happened to the order
+type OrderEventType string
+
+const (
+	// OrderUploaded is recorded when the user uploads the order
+	OrderUploaded OrderEventType = "uploaded"
+	// OrderPolled is a result of accrual system poll, Detail is the status reported by accrual system
+	OrderPolled OrderEventType = "polled"
+	// OrderCredited is recorded when the accrual is added to the user's balance
+	OrderCredited OrderEventType = "credited"
+)
+
+// AccrualNotRegistered is the poll result detail for orders unknown to accrual system
+const AccrualNotRegistered = "NOT_REGISTERED"
+
+// OrderEvent is a record of the order history
+type OrderEvent struct {
+	ID      int64          `json:"-"`
+	Order   int64          `json:"-"`
+	Type    OrderEventType `json:"type"`
+	Status  OrderStatus    `json:"status,omitempty"`
+	Accrual Points         `json:"accrual,omitempty"`
+	Detail  string         `json:"detail,omitempty"`
+	Created CreatedTime    `json:"created_at"`
+}
+
+// Repeats tells whether e records the same thing as the previous event of the order
+func (e OrderEvent) Repeats(previous OrderEvent) bool {
+	return e.Order == previous.Order && e.Type == previous.Type && e.Status == previous.Status &&
+		e.Accrual == previous.Accrual && e.Detail == previous.Detail
+}
+
+// OrderDetail is the order with its history oldest first
+type OrderDetail struct {
+	Order
+	Events []OrderEvent `json:"events"`
+}
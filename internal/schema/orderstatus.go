@@ -0,0 +1,114 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// OrderStatus is a state of order processing, the numeric values are stored in database
+type OrderStatus int64
+
+const (
+	StatusNew        OrderStatus = 1
+	StatusProcessing OrderStatus = 2
+	StatusInvalid    OrderStatus = 3
+	StatusProcessed  OrderStatus = 4
+)
+
+// Statuses reported by the accrual system
+const (
+	AccrualRegistered = "REGISTERED"
+	AccrualProcessing = "PROCESSING"
+	AccrualInvalid    = "INVALID"
+	AccrualProcessed  = "PROCESSED"
+)
+
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+var orderStatusNames = map[OrderStatus]string{
+	StatusNew:        "NEW",
+	StatusProcessing: "PROCESSING",
+	StatusInvalid:    "INVALID",
+	StatusProcessed:  "PROCESSED",
+}
+
+// orderTransitions lists statuses each status may move to, terminal statuses have none
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	StatusNew:        {StatusProcessing, StatusInvalid, StatusProcessed},
+	StatusProcessing: {StatusInvalid, StatusProcessed},
+	StatusInvalid:    {},
+	StatusProcessed:  {},
+}
+
+func (s OrderStatus) String() string {
+	if name, ok := orderStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int64(s))
+}
+
+func (s OrderStatus) IsTerminal() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// NonTerminalStatuses are statuses of orders that still have to be checked in accrual system
+func NonTerminalStatuses() (statuses []OrderStatus) {
+	for _, s := range []OrderStatus{StatusNew, StatusProcessing, StatusInvalid, StatusProcessed} {
+		if !s.IsTerminal() {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses
+}
+
+// StatusFromAccrual maps accrual system status to order status
+func StatusFromAccrual(accrualStatus string) (s OrderStatus, err error) {
+	switch accrualStatus {
+	case AccrualRegistered:
+		return StatusNew, nil
+	case AccrualProcessing:
+		return StatusProcessing, nil
+	case AccrualInvalid:
+		return StatusInvalid, nil
+	case AccrualProcessed:
+		return StatusProcessed, nil
+	default:
+		return 0, fmt.Errorf("unknown accrual status %q", accrualStatus)
+	}
+}
+
+func ParseOrderStatus(name string) (s OrderStatus, err error) {
+	for status, statusName := range orderStatusNames {
+		if statusName == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown order status %q", name)
+}
+
+func (s OrderStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *OrderStatus) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	status, err := ParseOrderStatus(name)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
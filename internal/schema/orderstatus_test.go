@@ -0,0 +1,32 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderStatusTransitions(t *testing.T) {
+
+	tests := []struct {
+		name string
+		from OrderStatus
+		to   OrderStatus
+		want bool
+	}{
+		{name: "test#1 - Positive: NEW to PROCESSING", from: StatusNew, to: StatusProcessing, want: true},
+		{name: "test#2 - Positive: NEW to PROCESSED", from: StatusNew, to: StatusProcessed, want: true},
+		{name: "test#3 - Positive: PROCESSING to INVALID", from: StatusProcessing, to: StatusInvalid, want: true},
+		{name: "test#4 - Negative: PROCESSING back to NEW", from: StatusProcessing, to: StatusNew, want: false},
+		{name: "test#5 - Negative: PROCESSED is terminal", from: StatusProcessed, to: StatusInvalid, want: false},
+		{name: "test#6 - Negative: INVALID is terminal", from: StatusInvalid, to: StatusProcessed, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.from.CanTransitionTo(tt.to))
+		})
+	}
+
+	assert.Equal(t, []OrderStatus{StatusNew, StatusProcessing}, NonTerminalStatuses())
+}
@@ -0,0 +1,38 @@
+package schema
+
+import "encoding/json"
+
+// OutboxTopic is the kind of change an outbox message tells about
+type OutboxTopic string
+
+const (
+	// TopicOrderUploaded payload is the uploaded Order
+	TopicOrderUploaded OutboxTopic = "order.uploaded"
+	// TopicOrderStatus payload is the Order in its new status
+	TopicOrderStatus OutboxTopic = "order.status_changed"
+	// TopicLedgerEntry payload is the LedgerEntry of an accrual, withdrawal or adjustment
+	TopicLedgerEntry OutboxTopic = "ledger.entry"
+)
+
+// OutboxMessage is a domain event saved in the same transaction as the change,
+// so it exists if and only if the change is committed
+type OutboxMessage struct {
+	ID          int64           `json:"id"`
+	User        string          `json:"user"`
+	Topic       OutboxTopic     `json:"topic"`
+	Order       int64           `json:"order,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"-"`
+	LastError   string          `json:"-"`
+	NextAttempt CreatedTime     `json:"-"`
+	Created     CreatedTime     `json:"created_at"`
+}
+
+// NewOutboxMessage creates the message of the user's change with payload marshaled to JSON
+func NewOutboxMessage(user string, topic OutboxTopic, order int64, payload any, created CreatedTime) (m OutboxMessage, err error) {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return m, err
+	}
+	return OutboxMessage{User: user, Topic: topic, Order: order, Payload: bytes, NextAttempt: created, Created: created}, nil
+}
@@ -0,0 +1,117 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Points is an exact amount of loyalty points kept in hundredths (kopecks),
+// in JSON it is a decimal number like 729.98
+type Points int64
+
+const PointsScale = 100
+
+var (
+	ErrPointsFormat    = errors.New("points value is not a decimal number")
+	ErrPointsPrecision = errors.New("points value has more than 2 decimal places")
+	ErrPointsRange     = errors.New("points value is out of range")
+)
+
+func ParsePoints(s string) (p Points, err error) {
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || !isDigits(whole) || (hasFrac && (frac == "" || !isDigits(frac))) {
+		return 0, fmt.Errorf("%w: %q", ErrPointsFormat, s)
+	}
+	//zeros beyond hundredths do not add precision
+	for len(frac) > 2 && strings.HasSuffix(frac, "0") {
+		frac = frac[:len(frac)-1]
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("%w: %q", ErrPointsPrecision, s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	value, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrPointsRange, s)
+	}
+	if negative {
+		value = -value
+	}
+	return Points(value), nil
+}
+
+// RoundPoints parses s as ParsePoints does but rounds places beyond hundredths half up,
+// it is for amounts computed by other systems, input of users is parsed strictly
+func RoundPoints(s string) (p Points, err error) {
+	_, frac, hasFrac := strings.Cut(s, ".")
+	if !hasFrac || len(frac) <= 2 {
+		return ParsePoints(s)
+	}
+	if !isDigits(frac) {
+		return 0, fmt.Errorf("%w: %q", ErrPointsFormat, s)
+	}
+	//s ends with frac, so the cut keeps hundredths
+	p, err = ParsePoints(s[:len(s)-len(frac)+2])
+	if err != nil {
+		return 0, err
+	}
+	if frac[2] >= '5' {
+		if strings.HasPrefix(s, "-") {
+			p--
+		} else {
+			p++
+		}
+	}
+	return p, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Points) String() string {
+	sign := ""
+	value := int64(p)
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	whole, frac := value/PointsScale, value%PointsScale
+	switch {
+	case frac == 0:
+		return fmt.Sprintf("%v%d", sign, whole)
+	case frac%10 == 0:
+		return fmt.Sprintf("%v%d.%d", sign, whole, frac/10)
+	default:
+		return fmt.Sprintf("%v%d.%02d", sign, whole, frac)
+	}
+}
+
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Points) UnmarshalJSON(b []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(b, &number); err != nil {
+		return fmt.Errorf("%w: %v", ErrPointsFormat, string(b))
+	}
+	value, err := ParsePoints(number.String())
+	if err != nil {
+		return err
+	}
+	*p = value
+	return nil
+}
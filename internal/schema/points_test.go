@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPointsJSON(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		json     string
+		want     Points
+		wantJSON string
+		wantErr  error
+	}{
+		{
+			name:     "test#1 - Positive: decimal number",
+			json:     `729.98`,
+			want:     72998,
+			wantJSON: `729.98`,
+		},
+		{
+			name:     "test#2 - Positive: integer number",
+			json:     `500`,
+			want:     50000,
+			wantJSON: `500`,
+		},
+		{
+			name:     "test#3 - Positive: one decimal place and trailing zeros",
+			json:     `5.300`,
+			want:     530,
+			wantJSON: `5.3`,
+		},
+		{
+			name:     "test#4 - Positive: negative number",
+			json:     `-0.05`,
+			want:     -5,
+			wantJSON: `-0.05`,
+		},
+		{
+			name:    "test#5 - Negative: more than 2 decimal places",
+			json:    `0.001`,
+			wantErr: ErrPointsPrecision,
+		},
+		{
+			name:    "test#6 - Negative: exponent notation",
+			json:    `1e3`,
+			wantErr: ErrPointsFormat,
+		},
+		{
+			name:    "test#7 - Negative: not a number",
+			json:    `"abc"`,
+			wantErr: ErrPointsFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Points
+			err := json.Unmarshal([]byte(tt.json), &p)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, p)
+			bytes, err := json.Marshal(p)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantJSON, string(bytes))
+		})
+	}
+}
+
+func TestRoundPoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Points
+		wantErr error
+	}{
+		{name: "test#1 - Positive: hundredths are kept", s: "729.98", want: 72998},
+		{name: "test#2 - Positive: rounded down", s: "5.334", want: 533},
+		{name: "test#3 - Positive: half is rounded up", s: "5.335", want: 534},
+		{name: "test#4 - Positive: negative half is rounded away from zero", s: "-0.005", want: -1},
+		{name: "test#5 - Positive: rounding carries", s: "9.999", want: 1000},
+		{name: "test#6 - Negative: not a number", s: "5.3x5", wantErr: ErrPointsFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := RoundPoints(tt.s)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, p)
+		})
+	}
+}
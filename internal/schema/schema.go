@@ -13,28 +13,13 @@ type ContextKey int
 
 const PKey1 ContextKey = 123455
 const CtxKeyUName ContextKey = 1343456
-
-type orderType map[string]int64
-
-var OrderStatus = orderType{
-	"NEW":        1,
-	"PROCESSING": 2,
-	"INVALID":    3,
-	"PROCESSED":  4,
-}
+const CtxKeyClaims ContextKey = 1343457
 
 type User struct {
-	User       string  `json:"login"`
-	Password   string  `json:"password"`
-	Accrual    float64 `json:"current,omitempty"`
-	Withdrawal float64 `json:"withdrawn,omitempty"`
-}
-
-func (u User) CheckIdentity(u2 *User) (ok bool) {
-	if u.User == u2.User && u.Password == u2.Password {
-		return true
-	}
-	return false
+	User       string `json:"login"`
+	Password   string `json:"password"`
+	Accrual    Points `json:"current,omitempty"`
+	Withdrawal Points `json:"withdrawn,omitempty"`
 }
 
 type CreatedTime time.Time
@@ -66,26 +51,61 @@ func (t *CreatedTime) UnmarshalJSON(b []byte) error {
 type Order struct {
 	Order   int64       `json:"number"`
 	User    string      `json:"user"`
-	Status  int64       `json:"status,omitempty"`
-	Accrual float64     `json:"accrual,omitempty"`
+	Status  OrderStatus `json:"status,omitempty"`
+	Accrual Points      `json:"accrual,omitempty"`
 	Created CreatedTime `json:"uploaded_at"`
+	//AccrualExpires is the expiry of the accrual credited when the order becomes PROCESSED
+	AccrualExpires CreatedTime `json:"-"`
+	//PollAttempts counts polls in a row answered that the accrual system does not know the order yet
+	PollAttempts int `json:"-"`
 }
 
+// Withdrawal is a debit of the user's points paying for the order, one per order
 type Withdrawal struct {
+	//ID is the ledger entry of the withdrawal, it orders withdrawals made at the same time
+	ID         int64       `json:"-"`
+	Order      int64       `json:"order,string"`
 	User       string      `json:"user"`
 	Processed  CreatedTime `json:"processed_at"`
-	Withdrawal float64     `json:"sum,omitempty"`
+	Withdrawal Points      `json:"sum,omitempty"`
 }
 
-type ByTimeDescending Withdrawals
+type Withdrawals []Withdrawal
 
-func (a ByTimeDescending) Len() int      { return len(a) }
-func (a ByTimeDescending) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a ByTimeDescending) Less(i, j int) bool {
-	return time.Time(a[i].Processed).Before(time.Time(a[i].Processed))
+type LedgerEntryType string
+
+const (
+	LedgerAccrual    LedgerEntryType = "accrual"
+	LedgerWithdrawal LedgerEntryType = "withdrawal"
+	LedgerAdjustment LedgerEntryType = "adjustment"
+	// LedgerExpiration debits what is left of an expired credit
+	LedgerExpiration LedgerEntryType = "expiration"
+)
+
+// LedgerEntry is an append-only points movement: credits are positive, debits are negative
+type LedgerEntry struct {
+	ID      int64           `json:"id"`
+	User    string          `json:"user"`
+	Type    LedgerEntryType `json:"type"`
+	Amount  Points          `json:"amount"`
+	Order   int64           `json:"order,omitempty"`
+	Created CreatedTime     `json:"created_at"`
+	//Expires is when what is left of the credit expires, zero never expires
+	Expires CreatedTime `json:"-"`
 }
 
-type Withdrawals []Withdrawal
+// Balance is derived from ledger entries
+type Balance struct {
+	Current   Points `json:"current"`
+	Withdrawn Points `json:"withdrawn"`
+}
+
+func (b *Balance) Apply(e LedgerEntry) {
+	b.Current += e.Amount
+	if e.Type == LedgerWithdrawal {
+		b.Withdrawn -= e.Amount
+	}
+}
 
 type Orders map[int64]Order
 
@@ -147,7 +167,29 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 }
 
 type OrderAccrualResponse struct {
-	Order   int64   `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string `json:"order"`
+	Status  string `json:"status"`
+	Accrual Points `json:"accrual,omitempty"`
+}
+
+// UnmarshalJSON rounds the accrual to hundredths, the accrual system is not bound by the precision of points
+func (r *OrderAccrualResponse) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Order   string      `json:"order"`
+		Status  string      `json:"status"`
+		Accrual json.Number `json:"accrual,omitempty"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*r = OrderAccrualResponse{Order: raw.Order, Status: raw.Status}
+	if raw.Accrual == "" {
+		return nil
+	}
+	accrual, err := RoundPoints(raw.Accrual.String())
+	if err != nil {
+		return err
+	}
+	r.Accrual = accrual
+	return nil
 }
@@ -0,0 +1,39 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithdrawalJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		withdrawal Withdrawal
+		want       string
+	}{
+		{
+			name: "test#1 - Positive: order number is a string",
+			withdrawal: Withdrawal{
+				Order:      2377225624,
+				User:       "user1",
+				Processed:  CreatedTime(time.Date(2020, 12, 9, 16, 9, 57, 0, time.UTC)),
+				Withdrawal: 50000,
+			},
+			want: `{"order":"2377225624","user":"user1","processed_at":"2020-12-09T16:09:57Z","sum":500}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bytes, err := json.Marshal(tt.withdrawal)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(bytes))
+
+			var back Withdrawal
+			assert.NoError(t, json.Unmarshal(bytes, &back))
+			assert.Equal(t, tt.withdrawal.Order, back.Order)
+		})
+	}
+}
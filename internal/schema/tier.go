@@ -0,0 +1,108 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TierPeriodMonths is the rolling period of accrued points the tier is based on
+const TierPeriodMonths = 12
+
+var ErrTiersFormat = errors.New("tiers are not a comma separated list of name:threshold:multiplier")
+
+// Tier is reached by accruing Threshold points within TierPeriodMonths, accruals of its users are multiplied by Multiplier
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  Points  `json:"threshold"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// noTier is the tier of users below the lowest threshold
+var noTier = Tier{Multiplier: 1}
+
+// Apply multiplies the accrual rounding to hundredths
+func (t Tier) Apply(accrual Points) Points {
+	if t.Multiplier <= 0 {
+		return accrual
+	}
+	return Points(math.Round(float64(accrual) * t.Multiplier))
+}
+
+// Tiers are ordered by threshold, no tiers means every user gets the accrual as is
+type Tiers []Tier
+
+// ParseTiers parses "Silver:1000:1.05,Gold:5000:1.1", empty string is no tiers
+func ParseTiers(s string) (tiers Tiers, err error) {
+	names := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 3 || fields[0] == "" || names[fields[0]] {
+			return nil, fmt.Errorf("%w: %q", ErrTiersFormat, item)
+		}
+		threshold, err := ParsePoints(fields[1])
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("%w: %q threshold", ErrTiersFormat, item)
+		}
+		multiplier, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || multiplier <= 0 || math.IsInf(multiplier, 0) {
+			return nil, fmt.Errorf("%w: %q multiplier", ErrTiersFormat, item)
+		}
+		names[fields[0]] = true
+		tiers = append(tiers, Tier{Name: fields[0], Threshold: threshold, Multiplier: multiplier})
+	}
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].Threshold < tiers[j].Threshold
+	})
+	return tiers, nil
+}
+
+// For is the highest tier reached by accrued points, users below the lowest threshold have the tier without name
+func (t Tiers) For(accrued Points) Tier {
+	tier := noTier
+	for _, next := range t {
+		if next.Threshold > accrued {
+			break
+		}
+		tier = next
+	}
+	return tier
+}
+
+// Find is the tier by name, unknown name is the tier without name
+func (t Tiers) Find(name string) Tier {
+	for _, tier := range t {
+		if tier.Name == name {
+			return tier
+		}
+	}
+	return noTier
+}
+
+// Next is the tier after the named one, ok is false for the highest tier
+func (t Tiers) Next(name string) (next Tier, ok bool) {
+	current := t.Find(name)
+	for _, tier := range t {
+		if tier.Threshold > current.Threshold || (current.Name == "" && tier.Threshold == 0) {
+			return tier, true
+		}
+	}
+	return Tier{}, false
+}
+
+// TierChange is a record of the user's tier history, empty tier is below the lowest threshold
+type TierChange struct {
+	ID      int64       `json:"-"`
+	User    string      `json:"-"`
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Accrued Points      `json:"accrued"`
+	Created CreatedTime `json:"changed_at"`
+}
@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		tiers Tiers
+		err   bool
+	}{
+		{name: "test#1 - Positive: no tiers", s: "", tiers: nil},
+		{name: "test#2 - Positive: sorted by threshold", s: "Gold:5000:1.1, Silver:1000:1.05",
+			tiers: Tiers{{Name: "Silver", Threshold: 100000, Multiplier: 1.05}, {Name: "Gold", Threshold: 500000, Multiplier: 1.1}}},
+		{name: "test#3 - Negative: missing multiplier", s: "Silver:1000", err: true},
+		{name: "test#4 - Negative: duplicate name", s: "Silver:1000:1.05,Silver:2000:1.1", err: true},
+		{name: "test#5 - Negative: negative threshold", s: "Silver:-1:1.05", err: true},
+		{name: "test#6 - Negative: zero multiplier", s: "Silver:1000:0", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers, err := ParseTiers(tt.s)
+			if tt.err {
+				assert.ErrorIs(t, err, ErrTiersFormat)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.tiers, tiers)
+		})
+	}
+}
+
+func TestTiers(t *testing.T) {
+	tiers, err := ParseTiers("Silver:1000:1.05,Gold:5000:1.1,Platinum:20000:1.2")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "", tiers.For(99999).Name)
+	assert.Equal(t, "Silver", tiers.For(100000).Name)
+	assert.Equal(t, "Platinum", tiers.For(10000000).Name)
+
+	next, ok := tiers.Next("")
+	assert.True(t, ok)
+	assert.Equal(t, "Silver", next.Name)
+	next, ok = tiers.Next("Gold")
+	assert.True(t, ok)
+	assert.Equal(t, "Platinum", next.Name)
+	_, ok = tiers.Next("Platinum")
+	assert.False(t, ok)
+
+	//the multiplier is rounded to hundredths, users without tier get accrual as is
+	assert.Equal(t, Points(76648), tiers.Find("Silver").Apply(72998))
+	assert.Equal(t, Points(72998), tiers.Find("").Apply(72998))
+	assert.Equal(t, Points(72998), tiers.Find("Unknown").Apply(72998))
+}
@@ -0,0 +1,36 @@
+package schema
+
+import "encoding/json"
+
+// Webhook is the user's subscription to order status changes
+type Webhook struct {
+	ID      int64       `json:"id"`
+	User    string      `json:"-"`
+	URL     string      `json:"url"`
+	Created CreatedTime `json:"created_at"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliveryDelivered WebhookDeliveryStatus = "delivered"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event sent to one webhook, it is retried while pending
+type WebhookDelivery struct {
+	ID             int64                 `json:"id"`
+	Webhook        int64                 `json:"webhook_id"`
+	URL            string                `json:"url"`
+	User           string                `json:"-"`
+	Order          int64                 `json:"order,string"`
+	Event          string                `json:"event"`
+	Payload        json.RawMessage       `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	ResponseStatus int                   `json:"response_status,omitempty"`
+	LastError      string                `json:"last_error,omitempty"`
+	NextAttempt    CreatedTime           `json:"next_attempt_at"`
+	Created        CreatedTime           `json:"created_at"`
+}
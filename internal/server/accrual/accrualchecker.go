@@ -2,14 +2,17 @@ package accrual
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
-	"net/url"
-	"strconv"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/alphaonly/gomartv2/internal/schema"
 	storage "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
-	"github.com/go-resty/resty/v2"
 )
 
 //Periodically checking orders' accrual from remote service
@@ -17,18 +20,67 @@ import (
 type Configuration struct {
 }
 
-func NewChecker(serviceAddress string, requestTime int64, storage storage.Storage) (c *Checker) {
+// Publisher is told about every order status or accrual change made by the checker
+type Publisher interface {
+	Publish(o schema.Order)
+}
+
+// NewChecker creates checker of orders in storage, publisher may be nil,
+// accruals credited by the checker expire by expiry and are multiplied by the user's tier of tiers
+func NewChecker(serviceAddress string, requestTime int64, workers int, storage storage.Storage, publisher Publisher, expiry schema.ExpiryPolicy, tiers schema.Tiers) (c *Checker) {
+	if workers < 1 {
+		workers = 1
+	}
+	backoff := NewBackoff(time.Second, time.Minute)
 	return &Checker{
 		serviceAddress: serviceAddress,
 		requestTime:    time.Duration(requestTime) * time.Millisecond,
+		workers:        workers,
 		storage:        storage,
+		publisher:      publisher,
+		expiry:         expiry,
+		tiers:          tiers,
+		client:         NewClient(serviceAddress, backoff),
+		backoff:        backoff,
+		retryMin:       time.Second,
+		retryMax:       5 * time.Minute,
+		inFlight:       newOrderSet(),
+		owner:          newOwnerID(),
+		lease:          leaseTime,
+	}
+}
+
+// leaseTime is how long a claimed order belongs to the checker if it crashes before release
+const leaseTime = 30 * time.Second
+
+// newOwnerID identifies the checker of this instance in order leases
+func newOwnerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
 	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal("cannot generate checker id")
+	}
+	return fmt.Sprintf("%v-%v-%v", host, os.Getpid(), hex.EncodeToString(id))
 }
 
 type Checker struct {
 	serviceAddress string
 	requestTime    time.Duration //200 * time.Millisecond
+	workers        int
 	storage        storage.Storage
+	publisher      Publisher
+	expiry         schema.ExpiryPolicy
+	tiers          schema.Tiers
+	client         *Client
+	backoff        *Backoff      //shared by all workers
+	retryMin       time.Duration //pauses between polls of orders accrual system does not know yet
+	retryMax       time.Duration
+	inFlight       *orderSet //orders being checked right now
+	owner          string
+	lease          time.Duration
 }
 type Response struct {
 	Order   int64   `json:"order"`
@@ -36,58 +88,178 @@ type Response struct {
 	Accrual float64 `json:"accrual"`
 }
 
-func (c Checker) Run(ctx context.Context) {
+// Run dispatches pending orders to the pool of workers until ctx is done
+func (c *Checker) Run(ctx context.Context) {
 	ticker := time.NewTicker(c.requestTime)
+	defer ticker.Stop()
 
-	baseURL := url.URL{
-		Scheme: "http",
-		Host:   c.serviceAddress,
+	jobs := make(chan schema.Order)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				c.check(ctx, o)
+				c.release(o.Order)
+			}
+		}()
 	}
+	defer wg.Wait()
+	defer close(jobs)
 
-	httpc := resty.New().
-		SetBaseURL(baseURL.String())
-
-doItAGain:
 	for {
 		select {
 		case <-ticker.C:
-			//Getting New unprocessed orders to make a request to accrual system
-			oList, err := c.storage.GetNewOrdersList(ctx)
-			if err != nil {
-				log.Fatal("can not get new orders list")
-			}
+			c.dispatch(ctx, jobs)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Checker) dispatch(ctx context.Context, jobs chan<- schema.Order) {
+	//All polling waits while accrual system asked to retry later
+	if c.backoff.Paused(time.Now()) {
+		return
+	}
+	//Claiming unprocessed orders, other instances do not poll them until released
+	oList, err := c.storage.ClaimOrders(ctx, c.owner, c.lease, c.workers*10)
+	if err != nil {
+		log.Printf("can not claim new orders: %v", err)
+		return
+	}
+
+	for orderNumber, data := range oList {
+		//an order is never polled twice at once
+		if !c.inFlight.Add(orderNumber) {
+			continue
+		}
+		if ctx.Err() != nil || c.backoff.Paused(time.Now()) {
+			c.release(orderNumber)
+			continue
+		}
+		select {
+		case jobs <- data:
+		case <-ctx.Done():
+			c.release(orderNumber)
+		}
+	}
+}
+
+// release gives the order back to other checkers
+func (c *Checker) release(orderNumber int64) {
+	//lease has to be released even if checker context is already done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.storage.ReleaseOrder(ctx, orderNumber, c.owner)
+	if err != nil {
+		log.Printf("unable to release order %v lease: %v", orderNumber, err)
+	}
+	c.inFlight.Remove(orderNumber)
+}
 
-			for orderNumber, data := range oList {
+func (c *Checker) check(ctx context.Context, data schema.Order) {
+	orderNumber := data.Order
 
-				orderNumberStr := strconv.Itoa(int(orderNumber))
-				req := httpc.R().
-					SetHeader("Accept", "application/json")
+	response, err := c.client.GetOrderAccrual(ctx, orderNumber)
+	if errors.Is(err, ErrRateLimited) {
+		log.Printf("accrual system polling paused until %v: %v", c.backoff.PausedUntil(), err)
+		return
+	}
+	if errors.Is(err, ErrNotRegistered) {
+		//orders unknown to accrual system are retried on their own schedule kept with the order,
+		//so that they are not claimed before it by any instance
+		next := time.Now().Add(exponential(c.retryMin, c.retryMax, data.PollAttempts))
+		c.postpone(ctx, orderNumber, data.PollAttempts+1, next)
+		log.Printf("order %v is not registered yet, next attempt at %v", orderNumber, next)
+		c.record(ctx, schema.OrderEvent{Order: orderNumber, Type: schema.OrderPolled, Status: data.Status, Detail: schema.AccrualNotRegistered})
+		return
+	}
+	if err != nil {
+		log.Printf("order %v response error: %v", orderNumber, err)
+		return
+	}
+	if data.PollAttempts > 0 {
+		c.postpone(ctx, orderNumber, 0, time.Time{})
+	}
 
-				response := schema.OrderAccrualResponse{}
-				resp, err := req.
-					SetResult(&response).
-					Get("api/orders/" + orderNumberStr)
-				if err != nil {
-					log.Printf("order %v response error: %v", orderNumber, resp)
-					continue
-				}
+	//accrual system status drives order state machine
+	status, err := schema.StatusFromAccrual(response.Status)
+	if err != nil {
+		log.Printf("order %v: %v", orderNumber, err)
+		return
+	}
+	c.record(ctx, schema.OrderEvent{Order: orderNumber, Type: schema.OrderPolled, Status: status, Accrual: response.Accrual, Detail: response.Status})
+	if status == data.Status {
+		return
+	}
+	data.Status = status
+	data.Accrual = response.Accrual
+	if status == schema.StatusProcessed {
+		data.AccrualExpires = c.expiry.Expires(time.Now())
+	}
 
-				if response.Status != "PROCESSED" {
-					continue
-				}
+	//status update, balance credit multiplied by the user's tier and the tier recompute are atomic and idempotent
+	credited, err := c.storage.UpdateOrderStatus(ctx, data, c.tiers)
+	if errors.Is(err, schema.ErrIllegalTransition) {
+		log.Printf("order %v status refused: %v", orderNumber, err)
+		return
+	}
+	if err != nil {
+		log.Printf("unable to update order %v status: %v", orderNumber, err)
+		return
+	}
+	if status == schema.StatusProcessed {
+		data.Accrual = credited
+	}
+	if c.publisher != nil {
+		c.publisher.Publish(data)
+	}
+	if status == schema.StatusProcessed {
+		c.record(ctx, schema.OrderEvent{Order: orderNumber, Type: schema.OrderCredited, Status: status, Accrual: credited})
+	}
+}
 
-				data.Accrual = response.Accrual
-				data.Status = schema.OrderStatus["PROCESSED"]
+// postpone saves the schedule of the order, a failure only makes the order polled earlier
+func (c *Checker) postpone(ctx context.Context, orderNumber int64, attempts int, next time.Time) {
+	err := c.storage.PostponeOrder(ctx, orderNumber, c.owner, attempts, next)
+	if err != nil {
+		log.Printf("unable to postpone order %v: %v", orderNumber, err)
+	}
+}
 
-				err = c.storage.SaveOrder(ctx, data)
-				if err != nil {
-					log.Fatal("unable to save order")
-				}
-			}
+// record adds the event to the order history, the history is for support and never stops polling
+func (c *Checker) record(ctx context.Context, e schema.OrderEvent) {
+	e.Created = schema.CreatedTime(time.Now())
+	err := c.storage.AddOrderEvent(ctx, e)
+	if err != nil {
+		log.Printf("unable to record order %v event %v: %v", e.Order, e.Type, err)
+	}
+}
 
-		case <-ctx.Done():
-			break doItAGain
-		}
+type orderSet struct {
+	mu     sync.Mutex
+	orders map[int64]struct{}
+}
+
+func newOrderSet() *orderSet {
+	return &orderSet{orders: make(map[int64]struct{})}
+}
+
+// Add returns false if the order is already in the set
+func (s *orderSet) Add(orderNumber int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[orderNumber]; ok {
+		return false
 	}
+	s.orders[orderNumber] = struct{}{}
+	return true
+}
 
+func (s *orderSet) Remove(orderNumber int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.orders, orderNumber)
 }
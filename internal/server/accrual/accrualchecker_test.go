@@ -0,0 +1,183 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	mem "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+type publishedOrders []schema.Order
+
+func (p *publishedOrders) Publish(o schema.Order) {
+	*p = append(*p, o)
+}
+
+func TestCheckerOrderHistory(t *testing.T) {
+	responses := []string{"", "", `{"order":"12345678903","status":"PROCESSING"}`, `{"order":"12345678903","status":"PROCESSED","accrual":729.98}`}
+	poll := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[poll]
+		poll++
+		if response == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	order := schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusNew, Created: schema.CreatedTime(time.Now())}
+	assert.NoError(t, s.SaveOrder(ctx, order))
+
+	published := &publishedOrders{}
+	c := NewChecker(ts.URL, 200, 1, s, published, schema.ExpiryPolicy{Months: 12}, nil)
+	for range responses {
+		o, err := s.GetOrder(ctx, order.Order)
+		assert.NoError(t, err)
+		c.check(ctx, *o)
+	}
+
+	events, err := s.GetOrderEvents(ctx, order.Order)
+	assert.NoError(t, err)
+	want := []schema.OrderEvent{
+		{Type: schema.OrderUploaded, Status: schema.StatusNew},
+		{Type: schema.OrderPolled, Status: schema.StatusNew, Detail: schema.AccrualNotRegistered},
+		{Type: schema.OrderPolled, Status: schema.StatusProcessing, Detail: "PROCESSING"},
+		{Type: schema.OrderPolled, Status: schema.StatusProcessed, Accrual: 72998, Detail: "PROCESSED"},
+		{Type: schema.OrderCredited, Status: schema.StatusProcessed, Accrual: 72998},
+	}
+	if assert.Len(t, events, len(want)) {
+		for i := range want {
+			assert.Equal(t, want[i].Type, events[i].Type, "event %v", i)
+			assert.Equal(t, want[i].Status, events[i].Status, "event %v", i)
+			assert.Equal(t, want[i].Accrual, events[i].Accrual, "event %v", i)
+			assert.Equal(t, want[i].Detail, events[i].Detail, "event %v", i)
+		}
+	}
+
+	//the credited accrual expires by the policy
+	expiring, err := s.GetExpiringPoints(ctx, "user1", time.Now().AddDate(0, 13, 0))
+	assert.NoError(t, err)
+	if assert.Len(t, expiring, 1) {
+		assert.Equal(t, schema.Points(72998), expiring[0].Amount)
+		assert.WithinDuration(t, time.Now().AddDate(0, 12, 0), time.Time(expiring[0].Expires), time.Minute)
+	}
+
+	//only changes are published
+	if assert.Len(t, *published, 2) {
+		assert.Equal(t, schema.StatusProcessing, (*published)[0].Status)
+		assert.Equal(t, schema.StatusProcessed, (*published)[1].Status)
+		assert.Equal(t, schema.Points(72998), (*published)[1].Accrual)
+	}
+}
+
+func TestCheckerTiers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"PROCESSED","accrual":1000}`))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	tiers, err := schema.ParseTiers("Silver:1000:1.5,Gold:5000:2")
+	assert.NoError(t, err)
+	c := NewChecker(ts.URL, 200, 1, s, nil, schema.ExpiryPolicy{}, tiers)
+
+	//the first credit is made without tier and moves the user to Silver, the second one is multiplied by Silver
+	for _, orderNumber := range []int64{12345678903, 79927398713} {
+		order := schema.Order{Order: orderNumber, User: "user1", Status: schema.StatusNew, Created: schema.CreatedTime(time.Now())}
+		assert.NoError(t, s.SaveOrder(ctx, order))
+		c.check(ctx, order)
+	}
+
+	balance, err := s.GetBalance(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, schema.Points(250000), balance.Current)
+	o, err := s.GetOrder(ctx, 79927398713)
+	assert.NoError(t, err)
+	assert.Equal(t, schema.Points(150000), o.Accrual)
+	//the history tells the amount credited, not the one reported by accrual system
+	events, err := s.GetOrderEvents(ctx, 79927398713)
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, events) {
+		last := events[len(events)-1]
+		assert.Equal(t, schema.OrderCredited, last.Type)
+		assert.Equal(t, schema.Points(150000), last.Accrual)
+	}
+
+	tier, err := s.GetUserTier(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Silver", tier)
+	changes, err := s.GetTierChanges(ctx, "user1")
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, "", changes[0].From)
+		assert.Equal(t, "Silver", changes[0].To)
+		assert.Equal(t, schema.Points(100000), changes[0].Accrued)
+	}
+}
+
+func TestCheckerRoundsAccrual(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":5.335}`))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	order := schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusNew, Created: schema.CreatedTime(time.Now())}
+	assert.NoError(t, s.SaveOrder(ctx, order))
+
+	//accrual with more than 2 decimal places is credited rounded, the order is not polled forever
+	c := NewChecker(ts.URL, 200, 1, s, nil, schema.ExpiryPolicy{}, nil)
+	c.check(ctx, order)
+
+	o, err := s.GetOrder(ctx, order.Order)
+	assert.NoError(t, err)
+	assert.Equal(t, schema.StatusProcessed, o.Status)
+	assert.Equal(t, schema.Points(534), o.Accrual)
+	balance, err := s.GetBalance(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, schema.Points(534), balance.Current)
+}
+
+func TestCheckerPostponesNotRegistered(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	assert.NoError(t, s.SaveOrder(ctx, schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusNew, Created: schema.CreatedTime(time.Now())}))
+	c := NewChecker(ts.URL, 200, 1, s, nil, schema.ExpiryPolicy{}, nil)
+
+	ol, err := s.ClaimOrders(ctx, c.owner, c.lease, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, ol, 1) {
+		c.check(ctx, ol[12345678903])
+		c.release(12345678903)
+	}
+
+	//the postponed order is skipped by every checker, a newer order is claimed instead
+	assert.NoError(t, s.SaveOrder(ctx, schema.Order{Order: 79927398713, User: "user1", Status: schema.StatusNew, Created: schema.CreatedTime(time.Now())}))
+	ol, err = s.ClaimOrders(ctx, "another", time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Len(t, ol, 1)
+	assert.Contains(t, ol, int64(79927398713))
+}
@@ -0,0 +1,66 @@
+package accrual
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff is a pause of all requests to accrual system shared by everyone who polls it.
+// Retry-After from the service wins, otherwise the pause grows exponentially with consecutive refusals.
+type Backoff struct {
+	mu          sync.Mutex
+	pausedUntil time.Time
+	refusals    int
+	min         time.Duration
+	max         time.Duration
+}
+
+func NewBackoff(min time.Duration, max time.Duration) *Backoff {
+	return &Backoff{min: min, max: max}
+}
+
+// Pause stops requests for retryAfter, or for adaptive interval if retryAfter is unknown
+func (b *Backoff) Pause(retryAfter time.Duration, now time.Time) (pause time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pause = retryAfter
+	if pause <= 0 {
+		pause = exponential(b.min, b.max, b.refusals)
+	}
+	b.refusals++
+	if until := now.Add(pause); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	return pause
+}
+
+// Reset is called after successful response
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refusals = 0
+}
+
+func (b *Backoff) Paused(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Before(b.pausedUntil)
+}
+
+func (b *Backoff) PausedUntil() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pausedUntil
+}
+
+func exponential(min time.Duration, max time.Duration, tries int) time.Duration {
+	d := min
+	for i := 0; i < tries && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,81 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	ErrNotRegistered = errors.New("order is not registered in accrual system")
+	ErrRateLimited   = errors.New("accrual system rate limit exceeded")
+)
+
+// Client requests accrual system respecting its rate limit
+type Client struct {
+	httpc   *resty.Client
+	backoff *Backoff
+}
+
+func NewClient(serviceAddress string, backoff *Backoff) *Client {
+	baseURL := serviceAddress
+	if !strings.Contains(serviceAddress, "://") {
+		baseURL = (&url.URL{Scheme: "http", Host: serviceAddress}).String()
+	}
+	return &Client{
+		httpc:   resty.New().SetBaseURL(baseURL),
+		backoff: backoff,
+	}
+}
+
+// GetOrderAccrual returns ErrRateLimited while requests are paused after 429,
+// and ErrNotRegistered on 204
+func (c *Client) GetOrderAccrual(ctx context.Context, orderNumber int64) (response schema.OrderAccrualResponse, err error) {
+	if c.backoff.Paused(time.Now()) {
+		return response, ErrRateLimited
+	}
+	resp, err := c.httpc.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetResult(&response).
+		Get("api/orders/" + strconv.FormatInt(orderNumber, 10))
+	if err != nil {
+		return response, fmt.Errorf("order %v request error: %w", orderNumber, err)
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		c.backoff.Reset()
+		return response, nil
+	case http.StatusNoContent:
+		c.backoff.Reset()
+		return response, ErrNotRegistered
+	case http.StatusTooManyRequests:
+		pause := c.backoff.Pause(parseRetryAfter(resp.Header().Get("Retry-After"), time.Now()), time.Now())
+		return response, fmt.Errorf("%w: paused for %v", ErrRateLimited, pause)
+	default:
+		return response, fmt.Errorf("order %v unexpected response status %v", orderNumber, resp.Status())
+	}
+}
+
+// parseRetryAfter accepts both delay in seconds and HTTP date, zero means unknown
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
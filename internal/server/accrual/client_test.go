@@ -0,0 +1,50 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientGetOrderAccrual(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/orders/12345678903":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":729.98}`))
+		case "/api/orders/9278923470":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	backoff := NewBackoff(time.Second, time.Minute)
+	client := NewClient(ts.URL, backoff)
+	ctx := context.Background()
+
+	response, err := client.GetOrderAccrual(ctx, 12345678903)
+	assert.NoError(t, err)
+	assert.Equal(t, "PROCESSED", response.Status)
+	assert.Equal(t, "729.98", response.Accrual.String())
+
+	_, err = client.GetOrderAccrual(ctx, 9278923470)
+	assert.True(t, errors.Is(err, ErrNotRegistered), "got error %v", err)
+
+	_, err = client.GetOrderAccrual(ctx, 1)
+	assert.True(t, errors.Is(err, ErrRateLimited), "got error %v", err)
+	assert.True(t, backoff.Paused(time.Now().Add(59*time.Second)))
+	assert.False(t, backoff.Paused(time.Now().Add(61*time.Second)))
+
+	//no requests are made while paused
+	_, err = client.GetOrderAccrual(ctx, 12345678903)
+	assert.True(t, errors.Is(err, ErrRateLimited), "got error %v", err)
+}
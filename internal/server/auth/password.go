@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//Password hashes are stored in bcrypt modular crypt format "$2a$<cost>$<salt+hash>",
+//so the algorithm and its cost travel with every row and can be upgraded later.
+//Rows without a known prefix are legacy plaintext passwords.
+
+const PasswordCost = bcrypt.DefaultCost
+
+var hashPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+func HashPassword(password string) (hash string, err error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", fmt.Errorf("cannot hash password %w", err)
+	}
+	return string(bytes), nil
+}
+
+func IsPasswordHashed(stored string) bool {
+	for _, prefix := range hashPrefixes {
+		if strings.HasPrefix(stored, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPassword compares password with stored value in constant time.
+// needsRehash reports that stored value is plaintext or was hashed with outdated parameters.
+func VerifyPassword(stored string, password string) (ok bool, needsRehash bool) {
+	if !IsPasswordHashed(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || cost != PasswordCost
+}
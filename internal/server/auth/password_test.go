@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyPassword(t *testing.T) {
+
+	hash, err := HashPassword("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		stored          string
+		password        string
+		wantOk          bool
+		wantNeedsRehash bool
+	}{
+		{
+			name:     "test#1 - Positive: hashed password matches",
+			stored:   hash,
+			password: "password1",
+			wantOk:   true,
+		},
+		{
+			name:     "test#2 - Negative: hashed password does not match",
+			stored:   hash,
+			password: "password2",
+			wantOk:   false,
+		},
+		{
+			name:            "test#3 - Positive: legacy plaintext password matches and needs rehash",
+			stored:          "password1",
+			password:        "password1",
+			wantOk:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:     "test#4 - Negative: legacy plaintext password does not match",
+			stored:   "password1",
+			password: "password2",
+			wantOk:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash := VerifyPassword(tt.stored, tt.password)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantNeedsRehash, needsRehash)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+//Signed expiring session tokens: base64url(claims JSON) + "." + base64url(HMAC-SHA256(claims))
+
+const (
+	CookieName   = "token"
+	BearerPrefix = "Bearer "
+)
+
+var (
+	ErrTokenMalformed = errors.New("token is malformed")
+	ErrTokenSignature = errors.New("token signature is invalid")
+	ErrTokenExpired   = errors.New("token is expired")
+)
+
+type Claims struct {
+	ID       string `json:"jti"`
+	Login    string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+func (c Claims) ExpiresAt() time.Time {
+	return time.Unix(c.Expires, 0)
+}
+
+type Tokens struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewTokens(key string, ttl time.Duration) *Tokens {
+	k := []byte(key)
+	if len(k) == 0 {
+		//no key configured: tokens will not survive restart and are not shared between replicas
+		k = make([]byte, 32)
+		if _, err := rand.Read(k); err != nil {
+			log.Fatal("cannot generate token signing key")
+		}
+		log.Println("auth: KEY is empty, random token signing key generated")
+	}
+	return &Tokens{key: k, ttl: ttl}
+}
+
+func (t Tokens) Issue(login string, now time.Time) (token string, claims Claims, err error) {
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return "", claims, fmt.Errorf("cannot generate token id %w", err)
+	}
+	claims = Claims{
+		ID:       hex.EncodeToString(id),
+		Login:    login,
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(t.ttl).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", claims, fmt.Errorf("cannot marshal token claims %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(t.sign(encoded)), claims, nil
+}
+
+func (t Tokens) Parse(token string, now time.Time) (claims Claims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, ErrTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrTokenMalformed
+	}
+	if !hmac.Equal(signature, t.sign(parts[0])) {
+		return claims, ErrTokenSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrTokenMalformed
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrTokenMalformed
+	}
+	if claims.Login == "" || claims.ID == "" {
+		return claims, ErrTokenMalformed
+	}
+	if !now.Before(claims.ExpiresAt()) {
+		return claims, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func (t Tokens) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
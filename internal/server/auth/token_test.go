@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokens(t *testing.T) {
+
+	now := time.Now()
+	tokens := NewTokens("secret", time.Hour)
+	token, claims, err := tokens.Issue("user1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tokens  *Tokens
+		token   string
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:    "test#1 - Positive: token is valid",
+			tokens:  tokens,
+			token:   token,
+			now:     now,
+			wantErr: nil,
+		},
+		{
+			name:    "test#2 - Negative: token is expired",
+			tokens:  tokens,
+			token:   token,
+			now:     now.Add(2 * time.Hour),
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name:    "test#3 - Negative: token signed with another key",
+			tokens:  NewTokens("another secret", time.Hour),
+			token:   token,
+			now:     now,
+			wantErr: ErrTokenSignature,
+		},
+		{
+			name:    "test#4 - Negative: token is malformed",
+			tokens:  tokens,
+			token:   "not-a-token",
+			now:     now,
+			wantErr: ErrTokenMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.tokens.Parse(tt.token, tt.now)
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				assert.Equal(t, claims, got)
+			}
+		})
+	}
+}
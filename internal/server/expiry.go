@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// expiryInterval is how often expired points are written off
+	expiryInterval = time.Hour
+	// expiryBatch is how many credits are written off by one storage call
+	expiryBatch = 100
+)
+
+// RunPointsExpiry writes off expired points until ctx is done, nothing expires without expiry months configured
+func (s *Server) RunPointsExpiry(ctx context.Context) {
+	if s.configuration.ExpiryPolicy().Months <= 0 {
+		return
+	}
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+	for {
+		s.expirePoints(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) expirePoints(ctx context.Context) {
+	policy := s.configuration.ExpiryPolicy()
+	total := 0
+	for ctx.Err() == nil {
+		n, err := s.InternalStorage.ExpirePoints(ctx, policy.Due(time.Now()), expiryBatch)
+		total += n
+		if err != nil {
+			log.Printf("unable to expire points: %v", err)
+			break
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("points of %v credits expired", total)
+	}
+}
@@ -5,43 +5,50 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"sort"
+	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/alphaonly/gomartv2/internal/domain"
 	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/alphaonly/gomartv2/internal/server/auth"
 	stor "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+	"github.com/alphaonly/gomartv2/internal/server/webhook"
 	"github.com/theplant/luhn"
 )
 
 type EntityHandler struct {
-	Storage         stor.Storage
-	AuthorizedUsers map[string]bool
+	Storage stor.Storage
+	Tokens  *auth.Tokens
+	//Expiry tells how far ahead the balance shows expiring points
+	Expiry schema.ExpiryPolicy
+	//Tiers are the loyalty tiers, the balance shows no tier without them
+	Tiers schema.Tiers
 }
 
-func NewEntityHandler(s stor.Storage) (eh *EntityHandler) {
+func NewEntityHandler(s stor.Storage, tokens *auth.Tokens) (eh *EntityHandler) {
 
 	return &EntityHandler{
-		Storage:         s,
-		AuthorizedUsers: make(map[string]bool),
+		Storage: s,
+		Tokens:  tokens,
 	}
 }
 func (eh EntityHandler) RegisterUser(ctx context.Context, u *schema.User) (err error) {
 	// data validation
 	if u.User == "" || u.Password == "" {
-		return errors.New("400 user or password is empty")
+		return domain.ErrEmptyCredentials
 	}
-	// Check if username exists
-	userChk, err := eh.Storage.GetUser(ctx, u.User)
-
+	//password is never stored in plaintext
+	hash, err := auth.HashPassword(u.Password)
 	if err != nil {
-		log.Printf("cannot get user from storage %v", err.Error())
+		return fmt.Errorf("cannot hash password %w", err)
 	}
-	if userChk != nil {
-		//login has already been occupied
-		return errors.New("409 login " + userChk.User + " is occupied")
+	//login is occupied atomically, an existing user is never overwritten
+	err = eh.Storage.CreateUser(ctx, &schema.User{User: u.User, Password: hash})
+	if errors.Is(err, stor.ErrUserExists) {
+		return err
 	}
-	err = eh.Storage.SaveUser(ctx, u)
 	if err != nil {
 		return fmt.Errorf("cannot save user in storage %w", err)
 	}
@@ -51,142 +58,463 @@ func (eh EntityHandler) RegisterUser(ctx context.Context, u *schema.User) (err e
 func (eh EntityHandler) AuthenticateUser(ctx context.Context, u *schema.User) (err error) {
 	// data validation
 	if u.User == "" || u.Password == "" {
-		return errors.New("400 user or password is empty")
+		return domain.ErrEmptyCredentials
 	}
 	// Check if username exists
 	userInStorage, err := eh.Storage.GetUser(ctx, u.User)
-	if !u.CheckIdentity(userInStorage) {
-		return errors.New("401 login or password is unknown")
+	if errors.Is(err, stor.ErrNotFound) {
+		return domain.ErrInvalidCredentials
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get user from storage %w", err)
+	}
+	ok, needsRehash := auth.VerifyPassword(userInStorage.Password, u.Password)
+	if !ok {
+		return domain.ErrInvalidCredentials
+	}
+	// Transparent upgrade of plaintext or outdated hashes on successful login
+	if needsRehash {
+		hash, err := auth.HashPassword(u.Password)
+		if err != nil {
+			log.Printf("cannot rehash password of user %v: %v", u.User, err)
+			return nil
+		}
+		err = eh.Storage.UpdateUserPassword(ctx, u.User, hash)
+		if err != nil {
+			log.Printf("cannot update password hash of user %v: %v", u.User, err)
+		}
 	}
-	eh.AuthorizedUsers[u.User] = true
 
 	return nil
 }
 
-func (eh EntityHandler) CheckIfUserAuthorized(user string) (ok bool, err error) {
+func (eh EntityHandler) IssueToken(userName string) (token string, claims auth.Claims, err error) {
+	// data validation
+	if userName == "" {
+		return "", claims, domain.ErrEmptyCredentials
+	}
+	return eh.Tokens.Issue(userName, time.Now())
+}
+
+func (eh EntityHandler) AuthorizeToken(ctx context.Context, token string) (claims auth.Claims, err error) {
 	// data validation
-	if user == "" {
-		return false, errors.New("400 login is empty")
+	if token == "" {
+		return claims, fmt.Errorf("%w: token is empty", domain.ErrUnauthorized)
+	}
+	// Check signature and expiration
+	claims, err = eh.Tokens.Parse(token, time.Now())
+	if err != nil {
+		return claims, fmt.Errorf("%w: %v", domain.ErrUnauthorized, err)
+	}
+	// Check if token was revoked by logout
+	revoked, err := eh.Storage.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return claims, fmt.Errorf("cannot check token revocation %w", err)
 	}
-	// Check if username authorized
-	return eh.AuthorizedUsers[user], nil
+	if revoked {
+		return claims, fmt.Errorf("%w: token %v is revoked", domain.ErrUnauthorized, claims.ID)
+	}
+	return claims, nil
+}
 
+func (eh EntityHandler) RevokeToken(ctx context.Context, claims auth.Claims) (err error) {
+	// data validation
+	if claims.ID == "" {
+		return fmt.Errorf("%w: token id is empty", domain.ErrUnauthorized)
+	}
+	err = eh.Storage.RevokeToken(ctx, claims.ID, claims.ExpiresAt())
+	if err != nil {
+		return fmt.Errorf("cannot revoke token %v %w", claims.ID, err)
+	}
+	return nil
 }
 
 func (eh EntityHandler) ValidateOrderNumber(ctx context.Context, orderNumberStr string, user string) (orderNum int64, err error) {
+	orderNumber, err := parseOrderNumber(orderNumberStr)
+	if err != nil {
+		return orderNumber, err
+	}
+	// Check if orderNumber had already existed, the order is accepted only when storage is sure it did not
+	orderChk, err := eh.Storage.GetOrder(ctx, orderNumber)
+	if errors.Is(err, stor.ErrNotFound) {
+		return orderNumber, nil
+	}
+	if err != nil {
+		return orderNumber, fmt.Errorf("cannot check order %v %w", orderNumber, err)
+	}
+	return orderNumber, uploadedError(*orderChk, user)
+}
+
+// parseOrderNumber checks the format of the order number
+func parseOrderNumber(orderNumberStr string) (orderNum int64, err error) {
 	orderNumber, err := strconv.Atoi(orderNumberStr)
 	if err != nil {
-		return 0, fmt.Errorf("400 order number bad number value %w", err)
+		return 0, fmt.Errorf("%w: %q", domain.ErrInvalidOrderNumber, orderNumberStr)
 	}
 	// order number format check
 	if orderNumber <= 0 {
-		return int64(orderNumber), fmt.Errorf("400 no order number zero or less:%v", orderNumber)
+		return int64(orderNumber), fmt.Errorf("%w: %v", domain.ErrInvalidOrderNumber, orderNumber)
 	}
 	// orderNumber number validation according Luhn algorithm
 	if !luhn.Valid(orderNumber) {
-		return int64(orderNumber), fmt.Errorf("422 no order number with Luhn: %v", orderNumber)
+		return int64(orderNumber), fmt.Errorf("%w: %v", domain.ErrInvalidLuhn, orderNumber)
 	}
-	// Check if orderNumber had already existed
-	orderChk, err := eh.Storage.GetOrder(ctx, int64(orderNumber))
+	return int64(orderNumber), nil
+}
+
+// uploadedError tells whether the uploaded order belongs to the user or to another one
+func uploadedError(o schema.Order, user string) error {
+	if user == o.User {
+		return fmt.Errorf("%w: %v", domain.ErrOrderExists, o.Order)
+	}
+	return fmt.Errorf("%w: %v", domain.ErrOrderOwnedByOther, o.Order)
+}
+
+// GetUsersOrders returns the page of user's orders, next is the cursor of the following page or nil for the last one
+func (eh EntityHandler) GetUsersOrders(ctx context.Context, userName string, q schema.ListQuery) (orders []schema.Order, next *schema.Cursor, err error) {
+	// data validation
+	if userName == "" {
+		return nil, nil, domain.ErrUnauthorized
+	}
+	//getOrders
+	orders, more, err := eh.Storage.GetOrdersList(ctx, userName, q)
 	if err != nil {
-		return int64(orderNumber), nil
+		return nil, nil, fmt.Errorf("cannot get orders of user %v %w", userName, err)
 	}
-	//Order exists, check user
-	if user == orderChk.User {
-		return int64(orderNumber), fmt.Errorf("200 order %v exists with user %v", orderNumber, user)
+	if more {
+		last := orders[len(orders)-1]
+		next = &schema.Cursor{Time: time.Time(last.Created), ID: last.Order}
 	}
-	return int64(orderNumber), fmt.Errorf("409 order %v exists with another user %v", orderNumber, orderChk.User)
+	return orders, next, nil
+}
+
+// MaxOrderBatch limits the number of orders uploaded by one request
+const MaxOrderBatch = 10000
+
+// OrderUploadResult is the outcome of one order of the batch, Status is what uploading the order alone responds
+type OrderUploadResult struct {
+	Order  string `json:"order"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
-func (eh EntityHandler) GetUsersOrders(ctx context.Context, userName string) (orders schema.Orders, err error) {
+// UploadOrders validates every order number of the batch as a single upload does and saves new orders in one transaction.
+// Invalid numbers do not fail the batch, storage errors do.
+func (eh EntityHandler) UploadOrders(ctx context.Context, userName string, numbers []string) (results []OrderUploadResult, err error) {
 	// data validation
 	if userName == "" {
-		return nil, fmt.Errorf("400 user %v is empty", userName)
+		return nil, domain.ErrUnauthorized
 	}
-	//getOrders
-	orderslist, err := eh.Storage.GetOrdersList(ctx, userName)
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("%w: no orders in the batch", domain.ErrBadRequest)
+	}
+	if len(numbers) > MaxOrderBatch {
+		return nil, fmt.Errorf("%w: batch of %v orders is over the limit of %v", domain.ErrBadRequest, len(numbers), MaxOrderBatch)
+	}
+	now := schema.CreatedTime(time.Now())
+	results = make([]OrderUploadResult, len(numbers))
+	parsed := make([]int64, len(numbers))
+	errs := make([]error, len(numbers))
+	var valid []int64
+	for i, number := range numbers {
+		results[i].Order = number
+		parsed[i], errs[i] = parseOrderNumber(number)
+		if errs[i] == nil {
+			valid = append(valid, parsed[i])
+		}
+	}
+	//numbers uploaded before are found by one query for the whole batch
+	uploaded := make(schema.Orders)
+	if len(valid) > 0 {
+		uploaded, err = eh.Storage.GetOrders(ctx, valid)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check batch of %v orders %w", len(valid), err)
+		}
+	}
+	var orders []schema.Order
+	inBatch := make(map[int64]int)
+	for i := range numbers {
+		orderNumber, err := parsed[i], errs[i]
+		if err == nil {
+			if o, ok := uploaded[orderNumber]; ok {
+				err = uploadedError(o, userName)
+			} else if _, ok := inBatch[orderNumber]; ok {
+				err = fmt.Errorf("%w: %v is repeated in the batch", domain.ErrOrderExists, orderNumber)
+			}
+		}
+		switch {
+		case err == nil:
+			inBatch[orderNumber] = i
+			orders = append(orders, schema.Order{Order: orderNumber, User: userName, Status: schema.StatusNew, Created: now})
+			results[i].Status = http.StatusAccepted
+		case errors.Is(err, domain.ErrOrderExists):
+			results[i].Status = http.StatusOK
+			results[i].Error = err.Error()
+		default:
+			results[i].Status = ErrorStatus(err)
+			results[i].Error = err.Error()
+		}
+	}
+	if len(orders) == 0 {
+		return results, nil
+	}
+	taken, err := eh.Storage.SaveOrders(ctx, orders)
+	if err != nil {
+		return nil, fmt.Errorf("cannot save batch of %v orders %w", len(orders), err)
+	}
+	//another user uploaded the number after it was checked
+	for _, orderNumber := range taken {
+		i := inBatch[orderNumber]
+		err = fmt.Errorf("%w: %v", domain.ErrOrderOwnedByOther, orderNumber)
+		results[i].Status = ErrorStatus(err)
+		results[i].Error = err.Error()
+	}
+	return results, nil
+}
+
+// CreateWebhook subscribes the user's webhook url to order status changes
+func (eh EntityHandler) CreateWebhook(ctx context.Context, userName string, webhookURL string) (w *schema.Webhook, err error) {
+	// data validation
+	if userName == "" {
+		return nil, domain.ErrUnauthorized
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", domain.ErrInvalidWebhookURL, webhookURL)
+	}
+	//the server's own network is not a webhook target
+	if err = webhook.CheckURL(u); err != nil {
+		return nil, err
+	}
+	w = &schema.Webhook{User: userName, URL: webhookURL, Created: schema.CreatedTime(time.Now())}
+	w.ID, err = eh.Storage.SaveWebhook(ctx, *w)
+	if err != nil {
+		return nil, fmt.Errorf("cannot save webhook of user %v %w", userName, err)
+	}
+	return w, nil
+}
+
+func (eh EntityHandler) GetWebhooks(ctx context.Context, userName string) (wl []schema.Webhook, err error) {
+	// data validation
+	if userName == "" {
+		return nil, domain.ErrUnauthorized
+	}
+	wl, err = eh.Storage.GetWebhooks(ctx, userName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get webhooks of user %v %w", userName, err)
+	}
+	return wl, nil
+}
+
+func (eh EntityHandler) DeleteWebhook(ctx context.Context, userName string, idStr string) (err error) {
+	// data validation
+	if userName == "" {
+		return domain.ErrUnauthorized
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", domain.ErrWebhookNotFound, idStr)
+	}
+	err = eh.Storage.DeleteWebhook(ctx, userName, id)
+	if errors.Is(err, stor.ErrNotFound) {
+		return fmt.Errorf("%w: %v", domain.ErrWebhookNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot delete webhook %v %w", id, err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries returns the page of the user's delivery log, next is the cursor of the following page or nil for the last one
+func (eh EntityHandler) GetWebhookDeliveries(ctx context.Context, userName string, q schema.ListQuery) (dl []schema.WebhookDelivery, next *schema.Cursor, err error) {
+	// data validation
+	if userName == "" {
+		return nil, nil, domain.ErrUnauthorized
+	}
+	dl, more, err := eh.Storage.GetWebhookDeliveries(ctx, userName, q)
 	if err != nil {
-		return nil, fmt.Errorf("204 no orders for user %v %w", userName, err)
+		return nil, nil, fmt.Errorf("cannot get webhook deliveries of user %v %w", userName, err)
 	}
-	return orderslist, nil
+	if more {
+		last := dl[len(dl)-1]
+		next = &schema.Cursor{Time: time.Time(last.Created), ID: last.ID}
+	}
+	return dl, next, nil
 }
 
 type UserBalanceResponse struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   schema.Points `json:"current"`
+	Withdrawn schema.Points `json:"withdrawn"`
+	//ExpiringSoon are the points expiring within Expiry.Soon, soonest first
+	ExpiringSoon []schema.ExpiringPoints `json:"expiring_soon"`
+	//Tier is set only if loyalty tiers are configured
+	Tier *TierProgress `json:"tier,omitempty"`
+}
+
+// TierProgress is the user's tier and what is left to accrue for the next one
+type TierProgress struct {
+	Name       string        `json:"name"`
+	Multiplier float64       `json:"multiplier"`
+	Accrued    schema.Points `json:"accrued_12m"`
+	//Next is empty for the highest tier
+	Next          string        `json:"next,omitempty"`
+	NextThreshold schema.Points `json:"next_threshold,omitempty"`
+	ToNext        schema.Points `json:"to_next,omitempty"`
 }
 
 func (eh EntityHandler) GetUserBalance(ctx context.Context, userName string) (response *UserBalanceResponse, err error) {
 	// data validation
 	if userName == "" {
-		return nil, fmt.Errorf("400 user %v is empty", userName)
+		return nil, domain.ErrUnauthorized
 	}
-	//getUser
-	user, err := eh.Storage.GetUser(ctx, userName)
+	//balance is derived from user's ledger
+	balance, err := eh.Storage.GetBalance(ctx, userName)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot get balance of user %v %w", userName, err)
+	}
+	expiring, err := eh.Storage.GetExpiringPoints(ctx, userName, eh.Expiry.SoonBefore(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get expiring points of user %v %w", userName, err)
 	}
-	return &UserBalanceResponse{user.Accrual, user.Withdrawal}, nil
+	response = &UserBalanceResponse{Current: balance.Current, Withdrawn: balance.Withdrawn, ExpiringSoon: expiring}
+	if len(eh.Tiers) > 0 {
+		response.Tier, err = eh.getTierProgress(ctx, userName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// getTierProgress shows the stored tier, the one accruals are multiplied by until the next credit recomputes it
+func (eh EntityHandler) getTierProgress(ctx context.Context, userName string) (p *TierProgress, err error) {
+	name, err := eh.Storage.GetUserTier(ctx, userName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get tier of user %v %w", userName, err)
+	}
+	accrued, err := eh.Storage.GetAccrued(ctx, userName, time.Now().AddDate(0, -schema.TierPeriodMonths, 0))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get accrued points of user %v %w", userName, err)
+	}
+	tier := eh.Tiers.Find(name)
+	p = &TierProgress{Name: tier.Name, Multiplier: tier.Multiplier, Accrued: accrued}
+	if next, ok := eh.Tiers.Next(name); ok {
+		p.Next = next.Name
+		p.NextThreshold = next.Threshold
+		if accrued < next.Threshold {
+			p.ToNext = next.Threshold - accrued
+		}
+	}
+	return p, nil
+}
+
+// GetTierHistory returns the user's tier changes oldest first
+func (eh EntityHandler) GetTierHistory(ctx context.Context, userName string) (cl []schema.TierChange, err error) {
+	// data validation
+	if userName == "" {
+		return nil, domain.ErrUnauthorized
+	}
+	cl, err = eh.Storage.GetTierChanges(ctx, userName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get tier history of user %v %w", userName, err)
+	}
+	return cl, nil
 }
 
 type UserWithdrawalRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order string        `json:"order"`
+	Sum   schema.Points `json:"sum"`
 }
 
-func (eh EntityHandler) MakeUserWithdrawal(ctx context.Context, userName string, request UserWithdrawalRequest) (err error) {
+// MaxIdempotencyKeyLength limits Idempotency-Key header value
+const MaxIdempotencyKeyLength = 255
+
+// MakeUserWithdrawal debits the user for the order, a retry with the same non-empty idempotencyKey
+// is replayed without debiting again
+func (eh EntityHandler) MakeUserWithdrawal(ctx context.Context, userName string, request UserWithdrawalRequest, idempotencyKey string) (replayed bool, err error) {
 	// data validation
 	if userName == "" {
-		return fmt.Errorf("400 user %v is empty", userName)
+		return false, domain.ErrUnauthorized
 	}
-	//check order number
+	if len(idempotencyKey) > MaxIdempotencyKeyLength {
+		return false, fmt.Errorf("%w: idempotency key is longer than %v", domain.ErrBadRequest, MaxIdempotencyKeyLength)
+	}
+	if request.Sum <= 0 {
+		return false, fmt.Errorf("%w: withdrawal sum %v", domain.ErrInvalidAmount, request.Sum)
+	}
+	//check order number, withdrawal is made against a new order,
+	//so an uploaded order is as unprocessable as a malformed number
 	orderNumber, err := eh.ValidateOrderNumber(ctx, request.Order, userName)
-	if err != nil {
-		return fmt.Errorf("422 order number invalid %v %w", orderNumber, err)
+	if errors.Is(err, domain.ErrInvalidOrderNumber) || errors.Is(err, domain.ErrInvalidLuhn) ||
+		errors.Is(err, domain.ErrOrderExists) || errors.Is(err, domain.ErrOrderOwnedByOther) {
+		return false, fmt.Errorf("%w: %v", domain.ErrUnprocessableOrder, err)
 	}
-	//getUser
-	user, err := eh.Storage.GetUser(ctx, userName)
 	if err != nil {
-		return err
+		return false, err
 	}
-	//Calculate new accrual
-	newAccrual := user.Accrual - request.Sum
-	if newAccrual < 0 {
-		return fmt.Errorf("402 insufficient funds for withdrawal")
+	//Debit user's ledger, balance check, ledger entry and idempotency key are made in one storage transaction
+	e := schema.LedgerEntry{
+		User:    userName,
+		Type:    schema.LedgerWithdrawal,
+		Amount:  -request.Sum,
+		Order:   orderNumber,
+		Created: schema.CreatedTime(time.Now()),
 	}
-	//Update Users data
-	user.Accrual = newAccrual
-	user.Withdrawal += request.Sum
-	err = eh.Storage.SaveUser(ctx, user)
-	if err != nil {
-		return fmt.Errorf("500 can not update data of user %v after withrawal attempt on order %v %w", userName, orderNumber, err)
+	replayed, err = eh.Storage.Withdraw(ctx, e, idempotencyKey)
+	if errors.Is(err, stor.ErrLedgerEntryExists) {
+		return false, fmt.Errorf("%w: order %v", domain.ErrWithdrawalExists, orderNumber)
 	}
-	//Add withdrawal
-	w := schema.Withdrawal{
-		User:       userName,
-		Processed:  schema.CreatedTime(time.Now()),
-		Withdrawal: request.Sum,
+	if errors.Is(err, domain.ErrInsufficientFunds) || errors.Is(err, domain.ErrIdempotencyKeyReused) {
+		return false, fmt.Errorf("withdrawal of %v on order %v %w", request.Sum, orderNumber, err)
 	}
-	err = eh.Storage.SaveWithdrawal(ctx, w)
 	if err != nil {
-		return fmt.Errorf("500 can not create withdrawal data of user %v after withrawal attempt on order %v %w", userName, orderNumber, err)
+		return false, fmt.Errorf("can not make withdrawal of user %v on order %v %w", userName, orderNumber, err)
 	}
-	return nil
+	return replayed, nil
 }
-func (eh EntityHandler) GetUsersWithdrawals(ctx context.Context, userName string) (withdrawals *schema.Withdrawals, err error) {
+
+// GetUserOrder returns the user's order with its history, orders of other users are not found as well as unknown ones
+func (eh EntityHandler) GetUserOrder(ctx context.Context, userName string, orderNumberStr string) (detail *schema.OrderDetail, err error) {
 	// data validation
 	if userName == "" {
-		return nil, fmt.Errorf("400 user %v is empty", userName)
+		return nil, domain.ErrUnauthorized
 	}
-	//getOrders
-	wList, err := eh.Storage.GetWithdrawalsList(ctx, userName)
+	orderNumber, err := strconv.ParseInt(orderNumberStr, 10, 64)
+	if err != nil || orderNumber <= 0 {
+		return nil, fmt.Errorf("%w: %q", domain.ErrInvalidOrderNumber, orderNumberStr)
+	}
+	//getOrder
+	order, err := eh.Storage.GetOrder(ctx, orderNumber)
+	if errors.Is(err, stor.ErrNotFound) || (err == nil && order.User != userName) {
+		return nil, fmt.Errorf("%w: %v", domain.ErrOrderNotFound, orderNumber)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get order %v %w", orderNumber, err)
+	}
+	events, err := eh.Storage.GetOrderEvents(ctx, orderNumber)
 	if err != nil {
-		return nil, fmt.Errorf("500 internal error on getting withdrawals for user %v %w", userName, err)
+		return nil, fmt.Errorf("cannot get order %v history %w", orderNumber, err)
 	}
-	if len(*wList) == 0 {
-		return nil, fmt.Errorf("204 no withdrawals for user %v %w", userName, err)
+	if events == nil {
+		events = []schema.OrderEvent{}
 	}
+	return &schema.OrderDetail{Order: *order, Events: events}, nil
+}
 
-	sort.Sort(schema.ByTimeDescending(*wList))
-	return wList, nil
+// GetUsersWithdrawals returns the page of user's withdrawals, next is the cursor of the following page or nil for the last one
+func (eh EntityHandler) GetUsersWithdrawals(ctx context.Context, userName string, q schema.ListQuery) (withdrawals *schema.Withdrawals, next *schema.Cursor, err error) {
+	// data validation
+	if userName == "" {
+		return nil, nil, domain.ErrUnauthorized
+	}
+	//getWithdrawals
+	withdrawals, more, err := eh.Storage.GetWithdrawalsList(ctx, userName, q)
+	if err != nil {
+		return nil, nil, fmt.Errorf("internal error on getting withdrawals for user %v %w", userName, err)
+	}
+	if more {
+		last := (*withdrawals)[len(*withdrawals)-1]
+		next = &schema.Cursor{Time: time.Time(last.Processed), ID: last.ID}
+	}
+	return withdrawals, next, nil
 }
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/alphaonly/gomartv2/internal/server/auth"
+	mem "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
+	stor "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+	"github.com/stretchr/testify/assert"
+)
+
+var errStorageDown = errors.New("connection refused")
+
+// downStorage fails reads as a storage with lost connection does
+type downStorage struct {
+	stor.Storage
+}
+
+func (s downStorage) GetUser(ctx context.Context, name string) (*schema.User, error) {
+	return nil, errStorageDown
+}
+
+func (s downStorage) CreateUser(ctx context.Context, u *schema.User) error {
+	return errStorageDown
+}
+
+func (s downStorage) GetOrder(ctx context.Context, orderNumber int64) (*schema.Order, error) {
+	return nil, errStorageDown
+}
+
+func (s downStorage) GetOrders(ctx context.Context, numbers []int64) (schema.Orders, error) {
+	return nil, errStorageDown
+}
+
+// racedStorage misses the orders other users upload between the check and the save
+type racedStorage struct {
+	stor.Storage
+}
+
+func (s racedStorage) GetOrders(ctx context.Context, numbers []int64) (schema.Orders, error) {
+	return make(schema.Orders), nil
+}
+
+func TestEntityHandlerStorageErrors(t *testing.T) {
+	ctx := context.Background()
+	tokens := auth.NewTokens("secret", time.Hour)
+	up := NewEntityHandler(mem.NewMemStorage(), tokens)
+	down := NewEntityHandler(downStorage{mem.NewMemStorage()}, tokens)
+	user := &schema.User{User: "user1", Password: "password1"}
+
+	tests := []struct {
+		name   string
+		call   func() error
+		status int
+	}{
+		{
+			name:   "test#1 - Positive: unknown order is new",
+			call:   func() error { _, err := up.ValidateOrderNumber(ctx, "12345678903", "user1"); return err },
+			status: http.StatusOK,
+		},
+		{
+			name:   "test#2 - Negative: order is not accepted when storage is down",
+			call:   func() error { _, err := down.ValidateOrderNumber(ctx, "12345678903", "user1"); return err },
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "test#3 - Negative: unknown login is unauthorized",
+			call:   func() error { return up.AuthenticateUser(ctx, user) },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "test#4 - Negative: login fails with internal error when storage is down",
+			call:   func() error { return down.AuthenticateUser(ctx, user) },
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "test#5 - Negative: registration fails with internal error when storage is down",
+			call:   func() error { return down.RegisterUser(ctx, user) },
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "test#6 - Negative: withdrawal fails with internal error when storage is down",
+			call: func() error {
+				_, err := down.MakeUserWithdrawal(ctx, "user1", UserWithdrawalRequest{Order: "2377225624", Sum: 100}, "")
+				return err
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if tt.status == http.StatusOK {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.status, ErrorStatus(err), "error %v", err)
+		})
+	}
+}
+
+func TestRegisterUserConcurrently(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	eh := NewEntityHandler(s, auth.NewTokens("secret", time.Hour))
+
+	//only one of concurrent registrations of the login succeeds, its password is kept
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			errs <- eh.RegisterUser(ctx, &schema.User{User: "user1", Password: "password" + strconv.Itoa(i)})
+		}(i)
+	}
+	registered := 0
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			registered++
+			continue
+		}
+		assert.Equal(t, http.StatusConflict, ErrorStatus(err), "error %v", err)
+	}
+	assert.Equal(t, 1, registered)
+}
+
+func TestMakeUserWithdrawalIdempotency(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	eh := NewEntityHandler(s, auth.NewTokens("secret", time.Hour))
+	assert.NoError(t, eh.RegisterUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	assert.NoError(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "user1", Type: schema.LedgerAdjustment, Amount: 1000}))
+	request := UserWithdrawalRequest{Order: "2377225624", Sum: 400}
+
+	tests := []struct {
+		name     string
+		request  UserWithdrawalRequest
+		key      string
+		replayed bool
+		status   int
+	}{
+		{name: "test#1 - Positive: first request debits", request: request, key: "key-1", status: http.StatusOK},
+		{name: "test#2 - Positive: retry is replayed", request: request, key: "key-1", replayed: true, status: http.StatusOK},
+		{name: "test#3 - Negative: key reused for another sum", request: UserWithdrawalRequest{Order: "2377225624", Sum: 500}, key: "key-1", status: http.StatusUnprocessableEntity},
+		{name: "test#4 - Negative: too long key", request: request, key: string(make([]byte, MaxIdempotencyKeyLength+1)), status: http.StatusBadRequest},
+		{name: "test#5 - Negative: second withdrawal for the order", request: request, key: "key-2", status: http.StatusConflict},
+		{name: "test#6 - Negative: second withdrawal for the order without key", request: request, status: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replayed, err := eh.MakeUserWithdrawal(ctx, "user1", tt.request, tt.key)
+			if tt.status != http.StatusOK {
+				assert.Equal(t, tt.status, ErrorStatus(err), "error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.replayed, replayed)
+		})
+	}
+
+	balance, err := eh.GetUserBalance(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, UserBalanceResponse{Current: 600, Withdrawn: 400, ExpiringSoon: []schema.ExpiringPoints{}}, *balance)
+}
+
+func TestGetUserBalanceExpiringSoon(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	eh := NewEntityHandler(s, auth.NewTokens("secret", time.Hour))
+	eh.Expiry = schema.ExpiryPolicy{Months: 12, Soon: 30 * 24 * time.Hour}
+	assert.NoError(t, eh.RegisterUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	soon := schema.CreatedTime(time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second))
+	later := schema.CreatedTime(time.Now().Add(60 * 24 * time.Hour))
+	assert.NoError(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "user1", Type: schema.LedgerAccrual, Amount: 200, Order: 2, Expires: later}))
+	assert.NoError(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "user1", Type: schema.LedgerAccrual, Amount: 300, Order: 1, Expires: soon}))
+	//the withdrawal takes the oldest credit first, whenever it expires
+	_, err := eh.MakeUserWithdrawal(ctx, "user1", UserWithdrawalRequest{Order: "2377225624", Sum: 100}, "")
+	assert.NoError(t, err)
+
+	balance, err := eh.GetUserBalance(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, UserBalanceResponse{
+		Current:      400,
+		Withdrawn:    100,
+		ExpiringSoon: []schema.ExpiringPoints{{Order: 1, Amount: 300, Expires: soon}},
+	}, *balance)
+
+	//the window comes from the policy
+	eh.Expiry.Soon = 24 * time.Hour
+	balance, err = eh.GetUserBalance(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Empty(t, balance.ExpiringSoon)
+}
+
+func TestGetUserBalanceTier(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	eh := NewEntityHandler(s, auth.NewTokens("secret", time.Hour))
+	tiers, err := schema.ParseTiers("Silver:1000:1.05,Gold:5000:1.1")
+	assert.NoError(t, err)
+	eh.Tiers = tiers
+	assert.NoError(t, eh.RegisterUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	//accruals older than the tier period are not counted
+	assert.NoError(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "user1", Type: schema.LedgerAccrual, Amount: 900000, Order: 1, Created: schema.CreatedTime(time.Now().AddDate(-2, 0, 0))}))
+	assert.NoError(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "user1", Type: schema.LedgerAccrual, Amount: 120000, Order: 2, Created: schema.CreatedTime(time.Now())}))
+	_, err = s.SetUserTier(ctx, schema.TierChange{User: "user1", To: "Silver", Accrued: 120000, Created: schema.CreatedTime(time.Now())})
+	assert.NoError(t, err)
+
+	balance, err := eh.GetUserBalance(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, &TierProgress{Name: "Silver", Multiplier: 1.05, Accrued: 120000, Next: "Gold", NextThreshold: 500000, ToNext: 380000}, balance.Tier)
+
+	history, err := eh.GetTierHistory(ctx, "user1")
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "Silver", history[0].To)
+	}
+
+	//without tiers the balance has no tier
+	eh.Tiers = nil
+	balance, err = eh.GetUserBalance(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Nil(t, balance.Tier)
+}
+
+func TestGetUserOrder(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	eh := NewEntityHandler(s, auth.NewTokens("secret", time.Hour))
+	assert.NoError(t, eh.RegisterUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+	order := schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusNew, Created: schema.CreatedTime(time.Now())}
+	assert.NoError(t, s.SaveOrder(ctx, order))
+	assert.NoError(t, s.AddOrderEvent(ctx, schema.OrderEvent{Order: order.Order, Type: schema.OrderUploaded, Status: schema.StatusNew, Created: order.Created}))
+
+	tests := []struct {
+		name   string
+		user   string
+		number string
+		status int
+	}{
+		{name: "test#1 - Positive: own order with history", user: "user1", number: "12345678903", status: http.StatusOK},
+		{name: "test#2 - Negative: order of another user is not found", user: "user2", number: "12345678903", status: http.StatusNotFound},
+		{name: "test#3 - Negative: unknown order", user: "user1", number: "9278923470", status: http.StatusNotFound},
+		{name: "test#4 - Negative: not a number", user: "user1", number: "12a", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detail, err := eh.GetUserOrder(ctx, tt.user, tt.number)
+			if tt.status != http.StatusOK {
+				assert.Equal(t, tt.status, ErrorStatus(err), "error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, order.Order, detail.Order.Order)
+			if assert.Len(t, detail.Events, 1) {
+				assert.Equal(t, schema.OrderUploaded, detail.Events[0].Type)
+			}
+		})
+	}
+}
+
+func TestUploadOrders(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	eh := NewEntityHandler(s, auth.NewTokens("secret", time.Hour))
+	assert.NoError(t, s.SaveOrder(ctx, schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusNew}))
+	assert.NoError(t, s.SaveOrder(ctx, schema.Order{Order: 2377225624, User: "user2", Status: schema.StatusNew}))
+
+	results, err := eh.UploadOrders(ctx, "user1", []string{"9278923470", "12345678903", "2377225624", "12345678901", "12a", "9278923470"})
+	assert.NoError(t, err)
+	statuses := make([]int, len(results))
+	for i, r := range results {
+		statuses[i] = r.Status
+	}
+	assert.Equal(t, []int{
+		http.StatusAccepted,
+		http.StatusOK,
+		http.StatusConflict,
+		http.StatusUnprocessableEntity,
+		http.StatusBadRequest,
+		http.StatusOK,
+	}, statuses)
+
+	ol, _, err := s.GetOrdersList(ctx, "user1", schema.ListQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, ol, 2)
+
+	_, err = eh.UploadOrders(ctx, "user1", nil)
+	assert.Equal(t, http.StatusBadRequest, ErrorStatus(err))
+	_, err = eh.UploadOrders(ctx, "user1", make([]string, MaxOrderBatch+1))
+	assert.Equal(t, http.StatusBadRequest, ErrorStatus(err))
+	_, err = NewEntityHandler(downStorage{mem.NewMemStorage()}, nil).UploadOrders(ctx, "user1", []string{"9278923470"})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, ErrorStatus(err))
+
+	//the number uploaded by another user after the check is refused by the save
+	results, err = NewEntityHandler(racedStorage{s}, nil).UploadOrders(ctx, "user1", []string{"2377225624", "79927398713"})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, http.StatusConflict, results[0].Status)
+		assert.Equal(t, http.StatusAccepted, results[1].Status)
+	}
+	order, err := s.GetOrder(ctx, 2377225624)
+	assert.NoError(t, err)
+	assert.Equal(t, "user2", order.User)
+}
+
+func TestCreateWebhook(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	eh := NewEntityHandler(s, auth.NewTokens("secret", time.Hour))
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{name: "test#1 - Positive: https url", url: "https://partner.example/hooks", want: http.StatusOK},
+		{name: "test#2 - Negative: same url again", url: "https://partner.example/hooks", want: http.StatusConflict},
+		{name: "test#3 - Negative: not http", url: "ftp://partner.example/hooks", want: http.StatusBadRequest},
+		{name: "test#4 - Negative: relative url", url: "/hooks", want: http.StatusBadRequest},
+		{name: "test#5 - Negative: empty url", url: "", want: http.StatusBadRequest},
+		{name: "test#6 - Negative: loopback address", url: "http://127.0.0.1:8080/hooks", want: http.StatusBadRequest},
+		{name: "test#7 - Negative: cloud metadata address", url: "http://169.254.169.254/latest/meta-data", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := eh.CreateWebhook(ctx, "user1", tt.url)
+			if tt.want == http.StatusOK {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.want, ErrorStatus(err))
+		})
+	}
+
+	wl, err := eh.GetWebhooks(ctx, "user1")
+	assert.NoError(t, err)
+	if assert.Len(t, wl, 1) {
+		assert.Equal(t, http.StatusNotFound, ErrorStatus(eh.DeleteWebhook(ctx, "user2", strconv.FormatInt(wl[0].ID, 10))))
+		assert.Equal(t, http.StatusNotFound, ErrorStatus(eh.DeleteWebhook(ctx, "user1", "abc")))
+		assert.NoError(t, eh.DeleteWebhook(ctx, "user1", strconv.FormatInt(wl[0].ID, 10)))
+	}
+}
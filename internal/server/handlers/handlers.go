@@ -2,29 +2,42 @@ package handlers
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/alphaonly/gomartv2/internal/server/auth"
+	"github.com/alphaonly/gomartv2/internal/server/hub"
 	storage "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+	"github.com/alphaonly/gomartv2/internal/server/webhook"
 
 	"github.com/alphaonly/gomartv2/internal/configuration"
+	"github.com/alphaonly/gomartv2/internal/domain"
 	"github.com/alphaonly/gomartv2/internal/schema"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 )
 
+const (
+	// IdempotencyKeyHeader makes a retried withdrawal debit only once
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks the response of a withdrawal made by an earlier request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
 type Handlers struct {
 	Storage       storage.Storage
 	Conf          configuration.ServerConfiguration
 	EntityHandler *EntityHandler
+	//Hub streams order updates, the stream is unavailable without it
+	Hub *hub.Hub
+	//Webhooks signs deliveries, webhooks cannot be created without it
+	Webhooks *webhook.Dispatcher
 }
 
 func (h *Handlers) WriteResponseBodyHandler() http.HandlerFunc {
@@ -115,14 +128,20 @@ func (h *Handlers) NewRouter() chi.Router {
 		r.Get("/check/", h.HandleCheckHealth)
 		r.Post("/api/user/register", h.PostValidation(h.HandlePostUserRegister(nil)))
 		r.Post("/api/user/login", h.PostValidation(h.HandlePostUserLogin(nil)))
-		r.Post("/api/user/orders", h.PostValidation(h.BasicUserAuthorization(h.HandlePostUserOrders(nil))))
-		r.Post("/api/user/balance/withdraw", h.PostValidation(h.BasicUserAuthorization(h.HandlePostUserBalanceWithdraw(nil))))
-		r.Get("/api/user/orders", h.GetValidation(h.BasicUserAuthorization(h.HandleGetUserOrders(nil))))
-		r.Get("/api/user/balance", h.GetValidation(h.BasicUserAuthorization(h.HandleGetUserBalance(nil))))
-		r.Get("/api/user/withdrawals", h.GetValidation(h.BasicUserAuthorization(h.HandleGetUserWithdrawals(nil))))
-
-		//Mock for accrual system (in case similar addresses) returns +5
-		r.Get("/api/orders/{number}", h.HandleGetOrderAccrual(nil))
+		r.Post("/api/user/logout", h.PostValidation(h.TokenUserAuthorization(h.HandlePostUserLogout(nil))))
+		r.Post("/api/user/orders", h.PostValidation(h.TokenUserAuthorization(h.HandlePostUserOrders(nil))))
+		r.Post("/api/user/balance/withdraw", h.PostValidation(h.TokenUserAuthorization(h.HandlePostUserBalanceWithdraw(nil))))
+		r.Post("/api/user/orders/batch", h.PostValidation(h.TokenUserAuthorization(h.HandlePostUserOrdersBatch(nil))))
+		r.Get("/api/user/orders", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserOrders(nil))))
+		r.Get("/api/user/orders/stream", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserOrdersStream(nil))))
+		r.Get("/api/user/orders/{number}", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserOrder(nil))))
+		r.Get("/api/user/balance", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserBalance(nil))))
+		r.Get("/api/user/withdrawals", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserWithdrawals(nil))))
+		r.Post("/api/user/webhooks", h.PostValidation(h.TokenUserAuthorization(h.HandlePostUserWebhook(nil))))
+		r.Get("/api/user/webhooks", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserWebhooks(nil))))
+		r.Get("/api/user/webhooks/deliveries", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserWebhookDeliveries(nil))))
+		r.Delete("/api/user/webhooks/{id}", h.TokenUserAuthorization(h.HandleDeleteUserWebhook(nil)))
+		r.Get("/api/user/tier/history", h.GetValidation(h.TokenUserAuthorization(h.HandleGetUserTierHistory(nil))))
 
 	})
 
@@ -148,7 +167,7 @@ func (h *Handlers) GetValidation(next http.Handler) http.HandlerFunc {
 		log.Println("HandleGetValidation invoked")
 		//Validation
 		if r.Method != http.MethodGet {
-			http.Error(w, "Only GET is allowed", http.StatusMethodNotAllowed)
+			httpError(w, errors.New("only GET is allowed"), http.StatusMethodNotAllowed)
 			return
 		}
 		if next != nil {
@@ -163,7 +182,7 @@ func (h *Handlers) PostValidation(next http.Handler) http.HandlerFunc {
 		log.Println("HandlePostValidation invoked")
 		//Validation
 		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+			httpError(w, errors.New("only POST is allowed"), http.StatusMethodNotAllowed)
 			return
 		}
 		if next != nil {
@@ -187,39 +206,56 @@ func (h *Handlers) HandlePostUserRegister(next http.Handler) http.HandlerFunc {
 		//Handling body
 		requestByteData, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Unrecognized json request ", http.StatusBadRequest)
+			httpErrorW(w, "unrecognized json request", err, http.StatusBadRequest)
 			return
 		}
 		u := new(schema.User)
 		err = json.Unmarshal(requestByteData, u)
 		if err != nil {
-			http.Error(w, "Error json-marshal request data", http.StatusBadRequest)
+			httpErrorW(w, "error json-unmarshal request data", err, http.StatusBadRequest)
 			return
 		}
 		//Logic
 		err = h.EntityHandler.RegisterUser(r.Context(), u)
 		if err != nil {
-			if strings.Contains(err.Error(), "400") {
-				http.Error(w, "login "+u.User+": bad request", http.StatusBadRequest)
-				return
-			}
-			if strings.Contains(err.Error(), "409") {
-				http.Error(w, "login "+u.User+"is occupied", http.StatusConflict)
-				return
-			}
-			http.Error(w, "login "+u.User+"register internal error", http.StatusInternalServerError)
+			writeError(w, err)
+			return
+		}
+		//Automatic authentication after registration
+		token, claims, err := h.EntityHandler.IssueToken(u.User)
+		if err != nil {
+			httpErrorW(w, "login "+u.User+" token issue error", err, http.StatusInternalServerError)
 			return
 		}
 		//Response
-		w.Header().Add("Authorization", "Basic "+basicAuth(u.User, u.Password))
+		setToken(w, token, claims)
 		w.WriteHeader(http.StatusOK)
 
 	}
 }
 
-func basicAuth(username, password string) string {
-	auth := username + ":" + password
-	return base64.StdEncoding.EncodeToString([]byte(auth))
+// setToken hands the session token to the client both as a cookie and as a bearer Authorization header
+func setToken(w http.ResponseWriter, token string, claims auth.Claims) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  claims.ExpiresAt(),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Authorization", auth.BearerPrefix+token)
+}
+
+// getToken takes the session token from bearer Authorization header or from the cookie
+func getToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, auth.BearerPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(header, auth.BearerPrefix))
+	}
+	if cookie, err := r.Cookie(auth.CookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
 }
 
 func (h *Handlers) HandlePostUserLogin(next http.Handler) http.HandlerFunc {
@@ -229,57 +265,60 @@ func (h *Handlers) HandlePostUserLogin(next http.Handler) http.HandlerFunc {
 		//Handling body
 		requestByteData, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Unrecognized json request ", http.StatusBadRequest)
+			httpErrorW(w, "unrecognized json request", err, http.StatusBadRequest)
 			return
 		}
 		u := new(schema.User)
 		err = json.Unmarshal(requestByteData, u)
 		if err != nil {
-			http.Error(w, "Error json-marshal request data", http.StatusBadRequest)
+			httpErrorW(w, "error json-unmarshal request data", err, http.StatusBadRequest)
 			return
 		}
 		//Logic
 		err = h.EntityHandler.AuthenticateUser(r.Context(), u)
 		if err != nil {
-			if strings.Contains(err.Error(), "400") {
-				http.Error(w, "login "+u.User+": bad request", http.StatusBadRequest)
-				return
-			}
-			if strings.Contains(err.Error(), "401") {
-				httpErrorW(w, "authorization error", err, http.StatusUnauthorized)
-				return
-			}
-			if strings.Contains(err.Error(), "409") {
-				http.Error(w, "login "+u.User+"is occupied", http.StatusConflict)
-				return
-			}
-			http.Error(w, "login "+u.User+"register internal error", http.StatusInternalServerError)
+			writeError(w, err)
+			return
+		}
+		token, claims, err := h.EntityHandler.IssueToken(u.User)
+		if err != nil {
+			httpErrorW(w, "login "+u.User+" token issue error", err, http.StatusInternalServerError)
 			return
 		}
 		//Response
+		setToken(w, token, claims)
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func (h *Handlers) BasicUserAuthorization(next http.Handler) http.HandlerFunc {
+func (h *Handlers) HandlePostUserLogout(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("BasicUserAuthorization invoked")
-		//Basic authentication
-		userBA, _, ok := r.BasicAuth()
-		if !ok {
-			http.Error(w, "basic authentication is not ok", http.StatusInternalServerError)
+		log.Println("HandlePostUserLogout invoked")
+		//Get parameters from previous handler
+		claims, err := getPreviousParameter[auth.Claims, schema.ContextKey](r, schema.CtxKeyClaims)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get token claims from context %w", err), http.StatusInternalServerError)
 			return
 		}
-		var err error
-		ok, err = h.EntityHandler.CheckIfUserAuthorized(userBA)
+		//Logic
+		err = h.EntityHandler.RevokeToken(r.Context(), claims)
 		if err != nil {
-			if strings.Contains(err.Error(), "400") {
-				httpError(w, fmt.Errorf("login %v: bad request %w", userBA, err), http.StatusBadRequest)
-				return
-			}
+			writeError(w, err)
+			return
 		}
-		if !ok {
-			httpError(w, errors.New("login "+userBA+" not authorized"), http.StatusBadRequest)
+		//Response
+		http.SetCookie(w, &http.Cookie{Name: auth.CookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (h *Handlers) TokenUserAuthorization(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("TokenUserAuthorization invoked")
+		//Token authentication
+		claims, err := h.EntityHandler.AuthorizeToken(r.Context(), getToken(r))
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 
@@ -287,7 +326,8 @@ func (h *Handlers) BasicUserAuthorization(next http.Handler) http.HandlerFunc {
 			log.Fatal("handler requires next handler not nil")
 		}
 		//call further handler with context parameters
-		ctx := context.WithValue(r.Context(), schema.CtxKeyUName, schema.CtxUName(userBA))
+		ctx := context.WithValue(r.Context(), schema.CtxKeyUName, schema.CtxUName(claims.Login))
+		ctx = context.WithValue(ctx, schema.CtxKeyClaims, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
@@ -308,33 +348,27 @@ func (h *Handlers) HandlePostUserOrders(next http.Handler) http.HandlerFunc {
 		}
 
 		orderNumber, err := h.EntityHandler.ValidateOrderNumber(r.Context(), string(requestByteData), string(user))
+		if errors.Is(err, domain.ErrOrderExists) {
+			log.Printf("order %v exists: %v", orderNumber, err.Error())
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		if err != nil {
-			if strings.Contains(err.Error(), "400") {
-				httpErrorW(w, fmt.Sprintf("order number  %v insufficient format", orderNumber), err, http.StatusBadRequest)
-				return
-			}
-			if strings.Contains(err.Error(), "422") {
-				httpErrorW(w, fmt.Sprintf("order %v insufficient format", orderNumber), err, http.StatusUnprocessableEntity)
-				return
-			}
-			if strings.Contains(err.Error(), "409") {
-				httpErrorW(w, fmt.Sprintf("order %v exists", orderNumber), err, http.StatusConflict)
-				return
-			}
-			if strings.Contains(err.Error(), "200") {
-				log.Printf("order %v exists: %v", orderNumber, err.Error())
-				w.WriteHeader(http.StatusOK)
-				return
-			}
+			writeError(w, err)
+			return
 		}
 		//Create object for a new order
 		o := schema.Order{
 			Order:   orderNumber,
 			User:    string(user),
-			Status:  schema.OrderStatus["NEW"],
+			Status:  schema.StatusNew,
 			Created: schema.CreatedTime(time.Now()),
 		}
 		err = h.Storage.SaveOrder(r.Context(), o)
+		if errors.Is(err, domain.ErrOrderOwnedByOther) {
+			writeError(w, err)
+			return
+		}
 		if err != nil {
 			httpErrorW(w, fmt.Sprintf("order's number %v not saved", orderNumber), err, http.StatusInternalServerError)
 			return
@@ -343,6 +377,40 @@ func (h *Handlers) HandlePostUserOrders(next http.Handler) http.HandlerFunc {
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
+func (h *Handlers) HandlePostUserOrdersBatch(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandlePostUserOrdersBatch invoked")
+		//Get parameters from previous handler
+		user, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		//Handling
+		numbers, err := readOrderBatch(w, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		results, err := h.EntityHandler.UploadOrders(r.Context(), string(user), numbers)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		//Response
+		bytes, err := json.Marshal(results)
+		if err != nil {
+			httpErrorW(w, fmt.Sprintf("user %v order batch results json marshal error", user), err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(bytes)
+		if err != nil {
+			log.Printf("user %v HandlePostUserOrdersBatch write response error: %v", user, err)
+		}
+	}
+}
 func (h *Handlers) HandleGetUserOrders(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("HandleGetUserOrders invoked")
@@ -353,10 +421,20 @@ func (h *Handlers) HandleGetUserOrders(next http.Handler) http.HandlerFunc {
 			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
 			return
 		}
+		q, err := parseListQuery(r, false, true)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 		//Handling
-		orderList, err := h.EntityHandler.GetUsersOrders(r.Context(), string(userName))
-		if strings.Contains(err.Error(), "204") {
-			httpErrorW(w, fmt.Sprintf("No orders for user %v", userName), err, http.StatusNoContent)
+		orderList, next, err := h.EntityHandler.GetUsersOrders(r.Context(), string(userName), q)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(orderList) == 0 {
+			log.Printf("no orders for user %v", userName)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 		//Response
@@ -365,13 +443,43 @@ func (h *Handlers) HandleGetUserOrders(next http.Handler) http.HandlerFunc {
 			httpErrorW(w, fmt.Sprintf("user %v order list json marshal error", userName), err, http.StatusInternalServerError)
 			return
 		}
+		setNextPage(w, r, next)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 		_, err = w.Write(bytes)
 		if err != nil {
-			httpErrorW(w, fmt.Sprintf("user %v HandleGetUserOrders write response error", userName), err, http.StatusInternalServerError)
+			log.Printf("user %v HandleGetUserOrders write response error: %v", userName, err)
+		}
+	}
+}
+func (h *Handlers) HandleGetUserOrder(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandleGetUserOrder invoked")
+
+		//Get parameters from previous handler
+		userName, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		//Handling
+		detail, err := h.EntityHandler.GetUserOrder(r.Context(), string(userName), chi.URLParam(r, "number"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		//Response
+		bytes, err := json.Marshal(detail)
+		if err != nil {
+			httpErrorW(w, fmt.Sprintf("user %v order %v json marshal error", userName, detail.Order.Order), err, http.StatusInternalServerError)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(bytes)
+		if err != nil {
+			log.Printf("user %v HandleGetUserOrder write response error: %v", userName, err)
+		}
 	}
 }
 func (h *Handlers) HandleGetUserBalance(next http.Handler) http.HandlerFunc {
@@ -386,7 +494,7 @@ func (h *Handlers) HandleGetUserBalance(next http.Handler) http.HandlerFunc {
 		//Handling
 		balance, err := h.EntityHandler.GetUserBalance(r.Context(), string(userName))
 		if err != nil {
-			httpError(w, fmt.Errorf("cannot get user data by userName %v from context %w", userName, err), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 		//Response
@@ -416,29 +524,23 @@ func (h *Handlers) HandlePostUserBalanceWithdraw(next http.Handler) http.Handler
 		//Handling
 		requestByteData, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Unrecognized json request ", http.StatusBadRequest)
+			httpErrorW(w, "unrecognized json request", err, http.StatusBadRequest)
 			return
 		}
 		userWithdrawalRequest := UserWithdrawalRequest{}
 		err = json.Unmarshal(requestByteData, &userWithdrawalRequest)
 		if err != nil {
-			http.Error(w, "Error json-marshal request data", http.StatusBadRequest)
+			httpErrorW(w, "Error json-marshal request data", err, http.StatusBadRequest)
 			return
 		}
-		err = h.EntityHandler.MakeUserWithdrawal(r.Context(), string(userName), userWithdrawalRequest)
+		replayed, err := h.EntityHandler.MakeUserWithdrawal(r.Context(), string(userName), userWithdrawalRequest, r.Header.Get(IdempotencyKeyHeader))
 		if err != nil {
-			if strings.Contains(err.Error(), "402") {
-				httpErrorW(w, "make withdrawal error", err, http.StatusPaymentRequired)
-				return
-			}
-			if strings.Contains(err.Error(), "422") {
-				httpErrorW(w, "order number invalid", err, http.StatusUnprocessableEntity)
-				return
-			}
-			if strings.Contains(err.Error(), "500") {
-				httpErrorW(w, "internal error", err, http.StatusInternalServerError)
-				return
-			}
+			writeError(w, err)
+			return
+		}
+		if replayed {
+			log.Printf("withdrawal of user %v on order %v is replayed", userName, userWithdrawalRequest.Order)
+			w.Header().Set(IdempotentReplayedHeader, "true")
 		}
 		//Response
 		w.WriteHeader(http.StatusOK)
@@ -453,74 +555,35 @@ func (h *Handlers) HandleGetUserWithdrawals(next http.Handler) http.HandlerFunc
 			httpError(w, fmt.Errorf("can not get userName from context %w", err), http.StatusInternalServerError)
 			return
 		}
-		//Handling
-		wList, err := h.EntityHandler.GetUsersWithdrawals(r.Context(), string(userName))
-		if err != nil {
-			if strings.Contains(err.Error(), "500") {
-				httpErrorW(w, "internal error", err, http.StatusInternalServerError)
-				return
-			}
-			if strings.Contains(err.Error(), "204") {
-				httpErrorW(w, "no withdrawals", err, http.StatusNoContent)
-				return
-			}
-		}
-		//Response
-		bytes, err := json.Marshal(wList)
-		if err != nil {
-			httpErrorW(w, fmt.Sprintf("user %v withdrawals list json marshal error", userName), err, http.StatusInternalServerError)
-			return
-		}
-		_, err = w.Write(bytes)
+		q, err := parseListQuery(r, true, false)
 		if err != nil {
-			httpErrorW(w, fmt.Sprintf("user %v withdrawals list write response error", userName), err, http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-	}
-}
-
-func (h *Handlers) HandleGetOrderAccrual(next http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("HandleGetOrderAccrual invoked")
 		//Handling
-		orderNumberStr := chi.URLParam(r, "number")
-		if orderNumberStr == "" {
-			httpError(w, fmt.Errorf("order number  %v is empty", orderNumberStr), http.StatusBadRequest)
-			return
-		}
-
-		orderNumber, err := strconv.ParseInt(orderNumberStr, 10, 64)
+		wList, next, err := h.EntityHandler.GetUsersWithdrawals(r.Context(), string(userName), q)
 		if err != nil {
-			httpError(w, fmt.Errorf("order number  %v is bad format", orderNumberStr), http.StatusBadRequest)
+			writeError(w, err)
 			return
 		}
-
-		accrual := 5.3
-
-		OrderAccrualResponse := schema.OrderAccrualResponse{
-			Order:   orderNumber,
-			Status:  "PROCESSED",
-			Accrual: accrual,
+		if len(*wList) == 0 {
+			log.Printf("no withdrawals for user %v", userName)
+			w.WriteHeader(http.StatusNoContent)
+			return
 		}
-
 		//Response
-		bytes, err := json.Marshal(&OrderAccrualResponse)
+		bytes, err := json.Marshal(wList)
 		if err != nil {
-			httpErrorW(w, "order accrual response json marshal error", err, http.StatusInternalServerError)
+			httpErrorW(w, fmt.Sprintf("user %v withdrawals list json marshal error", userName), err, http.StatusInternalServerError)
 			return
 		}
-
+		setNextPage(w, r, next)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-
 		_, err = w.Write(bytes)
 		if err != nil {
-			httpErrorW(w, "order accrual response write response error", err, http.StatusInternalServerError)
-			return
+			log.Printf("user %v withdrawals list write response error: %v", userName, err)
 		}
-
 	}
 }
 
@@ -534,7 +597,7 @@ func httpErrorW(w http.ResponseWriter, eStr string, err error, status int) {
 
 func httpError(w http.ResponseWriter, err error, status int) {
 	if err != nil {
-		http.Error(w, err.Error(), status)
+		writeProblem(w, err, status)
 		log.Println("server:" + err.Error())
 	}
 }
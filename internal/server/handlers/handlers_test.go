@@ -2,19 +2,18 @@ package handlers
 
 import (
 	"bytes"
-	"context"
 	"fmt"
-
-	"net/url"
+	"time"
 
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
-	storage "github.com/alphaonly/gomartv2/internal/server/storage/implementations/dbstorage"
+	"github.com/alphaonly/gomartv2/internal/server/auth"
+	storage "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
 )
 
-func TestHandleMetric(t *testing.T) {
+func TestHandleUser(t *testing.T) {
 
 	type want struct {
 		code        int
@@ -24,46 +23,46 @@ func TestHandleMetric(t *testing.T) {
 	type requestParams struct {
 		method string
 		url    string
+		body   string
 		want   want
 	}
 
-	data := url.Values{}
-
-	metricsRequestsParam := make(map[string]requestParams)
+	usersRequestsParam := make(map[string]requestParams)
 
-	contentType := "text/plain"
+	contentType := ""
 	urlPrefix := ""
-	//Check Url Ok
-	urlStr := urlPrefix + "/update/gauge/Alloc/2.36912E+05"
-	r1 := requestParams{method: http.MethodPost, url: urlStr,
-		want: want{code: http.StatusOK, response: `{"status":"ok"}`, contentType: contentType}}
-
-	//Check Url bad unknown metric
-	urlStr = urlPrefix + "/update/gauge/Alerrorloc/2.36912E+05"
-	r3 := requestParams{method: http.MethodPost, url: urlStr,
+	//Check health Ok
+	urlStr := urlPrefix + "/check/"
+	r1 := requestParams{method: http.MethodGet, url: urlStr,
 		want: want{code: http.StatusOK, response: ``, contentType: contentType}}
-	//Check Url bad method
-	urlStr = urlPrefix + "/update/gauge/Alloc/2.36912E+05"
+	//Check register Ok
+	urlStr = urlPrefix + "/api/user/register"
+	r2 := requestParams{method: http.MethodPost, url: urlStr, body: `{"login":"user1","password":"password1"}`,
+		want: want{code: http.StatusOK, response: ``, contentType: contentType}}
+	//Check register login is occupied
+	r3 := requestParams{method: http.MethodPost, url: urlStr, body: `{"login":"user1","password":"password2"}`,
+		want: want{code: http.StatusConflict, response: ``, contentType: contentType}}
+	//Check register empty password
+	r4 := requestParams{method: http.MethodPost, url: urlStr, body: `{"login":"user2","password":""}`,
+		want: want{code: http.StatusBadRequest, response: ``, contentType: contentType}}
+	//Check register bad method
 	r5 := requestParams{method: http.MethodGet, url: urlStr,
-		want: want{code: http.StatusMethodNotAllowed, response: `{"status":"ok"}`, contentType: contentType}}
-	//Check Url empty metric
-	urlStr = urlPrefix + "/update/gauge//2.36912E+05"
-	r6 := requestParams{method: http.MethodPost, url: urlStr,
-		want: want{code: http.StatusNotFound, response: `{"status":"ok"}`, contentType: contentType}}
-	//Check Url empty metric value
-	urlStr = urlPrefix + "/update/gauge/counter/"
-	r7 := requestParams{method: http.MethodPost, url: urlStr,
-		want: want{code: http.StatusNotFound, response: `{"status":"ok"}`, contentType: contentType}}
-
-	//var r4 requestParams
-
-	metricsRequestsParam["r1"] = r1
-
-	metricsRequestsParam["r3"] = r3
-
-	metricsRequestsParam["r5"] = r5
-	metricsRequestsParam["r6"] = r6
-	metricsRequestsParam["r7"] = r7
+		want: want{code: http.StatusMethodNotAllowed, response: ``, contentType: contentType}}
+	//Check orders list without token
+	urlStr = urlPrefix + "/api/user/orders"
+	r6 := requestParams{method: http.MethodGet, url: urlStr,
+		want: want{code: http.StatusUnauthorized, response: ``, contentType: contentType}}
+	//Check order upload without token
+	r7 := requestParams{method: http.MethodPost, url: urlStr, body: `12345678903`,
+		want: want{code: http.StatusUnauthorized, response: ``, contentType: contentType}}
+
+	usersRequestsParam["r1"] = r1
+	usersRequestsParam["r2"] = r2
+	usersRequestsParam["r3"] = r3
+	usersRequestsParam["r4"] = r4
+	usersRequestsParam["r5"] = r5
+	usersRequestsParam["r6"] = r6
+	usersRequestsParam["r7"] = r7
 
 	tests := []struct {
 		name string
@@ -73,35 +72,43 @@ func TestHandleMetric(t *testing.T) {
 		{
 			name: "test#1 positive",
 			ID:   "r1",
-			want: metricsRequestsParam["r1"].want,
+			want: usersRequestsParam["r1"].want,
+		},
+		{
+			name: "test#2 positive",
+			ID:   "r2",
+			want: usersRequestsParam["r2"].want,
 		},
-
 		{
 			name: "test#3 negative",
 			ID:   "r3",
-			want: metricsRequestsParam["r3"].want,
+			want: usersRequestsParam["r3"].want,
+		},
+		{
+			name: "test#4 negative",
+			ID:   "r4",
+			want: usersRequestsParam["r4"].want,
 		},
-
 		{
 			name: "test#5 negative",
 			ID:   "r5",
-			want: metricsRequestsParam["r5"].want,
+			want: usersRequestsParam["r5"].want,
 		},
 		{
 			name: "test#6 negative",
 			ID:   "r6",
-			want: metricsRequestsParam["r6"].want,
+			want: usersRequestsParam["r6"].want,
 		},
 		{
 			name: "test#7 negative",
 			ID:   "r7",
-			want: metricsRequestsParam["r7"].want,
+			want: usersRequestsParam["r7"].want,
 		},
 	}
 	fmt.Println("start!")
 
-	s := storage.NewDBStorage(context.Background(), "")
-	h := Handlers{Storage: s}
+	s := storage.NewMemStorage()
+	h := Handlers{Storage: s, EntityHandler: NewEntityHandler(s, auth.NewTokens("secret", time.Hour))}
 
 	r := h.NewRouter()
 
@@ -110,18 +117,12 @@ func TestHandleMetric(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			fmt.Println("url from test:" + metricsRequestsParam[tt.ID].url)
+			fmt.Println("url from test:" + usersRequestsParam[tt.ID].url)
 
-			request := httptest.NewRequest(metricsRequestsParam[tt.ID].method, metricsRequestsParam[tt.ID].url, bytes.NewBufferString(data.Encode()))
+			request := httptest.NewRequest(usersRequestsParam[tt.ID].method, usersRequestsParam[tt.ID].url, bytes.NewBufferString(usersRequestsParam[tt.ID].body))
 
 			w := httptest.NewRecorder()
 
-			//h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			//
-			//	handlers := Handlers{}
-			//	handlers.HandlePostMetric(w, r)
-			//})
-
 			r.ServeHTTP(w, request)
 
 			response := w.Result()
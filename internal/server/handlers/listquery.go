@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/alphaonly/gomartv2/internal/schema"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// parseListQuery reads ?limit=&after=&status=&from=&to=&sort= of a listing request,
+// descending is the sort order when sort is not given, statuses are only accepted for orders
+func parseListQuery(r *http.Request, descending bool, withStatus bool) (q schema.ListQuery, err error) {
+	values := r.URL.Query()
+	q = schema.ListQuery{Limit: DefaultPageLimit, Descending: descending}
+
+	if s := values.Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit < 1 || q.Limit > MaxPageLimit {
+			return q, fmt.Errorf("%w: limit must be from 1 to %v, got %v", domain.ErrBadRequest, MaxPageLimit, s)
+		}
+	}
+	if s := values.Get("after"); s != "" {
+		after, err := schema.ParseCursor(s)
+		if err != nil {
+			return q, fmt.Errorf("%w: %v", domain.ErrBadRequest, err)
+		}
+		q.After = &after
+	}
+	for _, s := range values["status"] {
+		if !withStatus {
+			return q, fmt.Errorf("%w: status filter is not supported", domain.ErrBadRequest)
+		}
+		for _, name := range strings.Split(s, ",") {
+			status, err := schema.ParseOrderStatus(strings.TrimSpace(name))
+			if err != nil {
+				return q, fmt.Errorf("%w: %v", domain.ErrBadRequest, err)
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+	if q.From, err = parseTimeParam(values.Get("from")); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTimeParam(values.Get("to")); err != nil {
+		return q, err
+	}
+	switch s := values.Get("sort"); s {
+	case "":
+	case "asc":
+		q.Descending = false
+	case "desc":
+		q.Descending = true
+	default:
+		return q, fmt.Errorf("%w: sort must be asc or desc, got %v", domain.ErrBadRequest, s)
+	}
+	return q, nil
+}
+
+func parseTimeParam(s string) (t time.Time, err error) {
+	if s == "" {
+		return t, nil
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("%w: time must be in RFC3339 format, got %v", domain.ErrBadRequest, s)
+	}
+	return t, nil
+}
+
+// setNextPage tells the client where the following page starts, keeping the other parameters of the request
+func setNextPage(w http.ResponseWriter, r *http.Request, next *schema.Cursor) {
+	if next == nil {
+		return
+	}
+	values := r.URL.Query()
+	values.Set("after", next.String())
+	u := *r.URL
+	u.RawQuery = values.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%v>; rel=\"next\"", u.RequestURI()))
+	w.Header().Set(NextCursorHeader, next.String())
+}
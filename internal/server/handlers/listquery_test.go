@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseListQuery(t *testing.T) {
+	cursor := schema.Cursor{Time: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC), ID: 79927398713}
+	tests := []struct {
+		name       string
+		query      string
+		withStatus bool
+		want       schema.ListQuery
+		status     int
+	}{
+		{
+			name:   "test#1 - Positive: defaults",
+			want:   schema.ListQuery{Limit: DefaultPageLimit},
+			status: http.StatusOK,
+		},
+		{
+			name:       "test#2 - Positive: all parameters",
+			query:      "limit=2&after=" + cursor.String() + "&status=NEW,PROCESSED&status=INVALID&from=2023-03-01T00:00:00Z&to=2023-03-02T00:00:00Z&sort=desc",
+			withStatus: true,
+			want: schema.ListQuery{
+				Limit:      2,
+				After:      &cursor,
+				Statuses:   []schema.OrderStatus{schema.StatusNew, schema.StatusProcessed, schema.StatusInvalid},
+				From:       time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+				To:         time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC),
+				Descending: true,
+			},
+			status: http.StatusOK,
+		},
+		{name: "test#3 - Negative: zero limit", query: "limit=0", status: http.StatusBadRequest},
+		{name: "test#4 - Negative: too big limit", query: "limit=1001", status: http.StatusBadRequest},
+		{name: "test#5 - Negative: bad cursor", query: "after=xyz", status: http.StatusBadRequest},
+		{name: "test#6 - Negative: unknown status", query: "status=LOST", withStatus: true, status: http.StatusBadRequest},
+		{name: "test#7 - Negative: status of withdrawals", query: "status=NEW", status: http.StatusBadRequest},
+		{name: "test#8 - Negative: bad date", query: "from=2023-03-01", status: http.StatusBadRequest},
+		{name: "test#9 - Negative: bad sort", query: "sort=up", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/user/orders?"+tt.query, nil)
+			q, err := parseListQuery(r, false, tt.withStatus)
+			if tt.status != http.StatusOK {
+				assert.Equal(t, tt.status, ErrorStatus(err), "error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, q)
+		})
+	}
+}
+
+func TestSetNextPage(t *testing.T) {
+	next := schema.Cursor{Time: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC), ID: 2377225624}
+	r := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?limit=2&sort=asc", nil)
+
+	w := httptest.NewRecorder()
+	setNextPage(w, r, nil)
+	assert.Empty(t, w.Header().Get("Link"))
+
+	setNextPage(w, r, &next)
+	assert.Equal(t, "</api/user/withdrawals?after="+next.String()+"&limit=2&sort=asc>; rel=\"next\"", w.Header().Get("Link"))
+	assert.Equal(t, next.String(), w.Header().Get(NextCursorHeader))
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+)
+
+// MaxOrderBatchBytes limits the body of batch upload, enough for MaxOrderBatch numbers
+const MaxOrderBatchBytes = 1 << 20
+
+// parseOrderBatch reads order numbers from a JSON array of strings or numbers,
+// or from a newline-delimited list where blank lines are skipped
+func parseOrderBatch(contentType string, body []byte) (numbers []string, err error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" && !bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		for _, line := range strings.Split(string(body), "\n") {
+			if number := strings.TrimSpace(line); number != "" {
+				numbers = append(numbers, number)
+			}
+		}
+		return numbers, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("%w: orders must be a JSON array: %v", domain.ErrBadRequest, err)
+	}
+	numbers = make([]string, len(items))
+	for i, item := range items {
+		//numbers keep their literal text, so an invalid one gets its own result instead of failing the batch
+		if bytes.HasPrefix(item, []byte(`"`)) {
+			if err := json.Unmarshal(item, &numbers[i]); err != nil {
+				return nil, fmt.Errorf("%w: order %v: %v", domain.ErrBadRequest, i, err)
+			}
+			continue
+		}
+		numbers[i] = string(item)
+	}
+	return numbers, nil
+}
+
+// readOrderBatch parses the body of batch upload request
+func readOrderBatch(w http.ResponseWriter, r *http.Request) (numbers []string, err error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxOrderBatchBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unrecognized request body: %v", domain.ErrBadRequest, err)
+	}
+	return parseOrderBatch(r.Header.Get("Content-Type"), body)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOrderBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []string
+		status      int
+	}{
+		{
+			name:        "test#1 - Positive: newline-delimited list",
+			contentType: "text/plain",
+			body:        "12345678903\r\n\n 9278923470 \n",
+			want:        []string{"12345678903", "9278923470"},
+			status:      http.StatusOK,
+		},
+		{
+			name:        "test#2 - Positive: JSON array of strings and numbers",
+			contentType: "application/json; charset=utf-8",
+			body:        `["12345678903", 9278923470, "12a"]`,
+			want:        []string{"12345678903", "9278923470", "12a"},
+			status:      http.StatusOK,
+		},
+		{
+			name:   "test#3 - Positive: JSON array without content type",
+			body:   ` ["12345678903"]`,
+			want:   []string{"12345678903"},
+			status: http.StatusOK,
+		},
+		{
+			name:        "test#4 - Negative: JSON object",
+			contentType: "application/json",
+			body:        `{"order":"12345678903"}`,
+			status:      http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			numbers, err := parseOrderBatch(tt.contentType, []byte(tt.body))
+			if tt.status != http.StatusOK {
+				assert.Equal(t, tt.status, ErrorStatus(err), "error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, numbers)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is the body of every error response, RFC 7807 problem details
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// problemStatuses maps domain errors to response statuses, errors not listed here are internal ones
+var problemStatuses = []struct {
+	err    error
+	status int
+}{
+	{domain.ErrEmptyCredentials, http.StatusBadRequest},
+	{domain.ErrInvalidOrderNumber, http.StatusBadRequest},
+	{domain.ErrInvalidAmount, http.StatusBadRequest},
+	{domain.ErrBadRequest, http.StatusBadRequest},
+	{domain.ErrInvalidWebhookURL, http.StatusBadRequest},
+	{domain.ErrWebhookNotPublic, http.StatusBadRequest},
+	{domain.ErrInvalidCredentials, http.StatusUnauthorized},
+	{domain.ErrUnauthorized, http.StatusUnauthorized},
+	{domain.ErrInsufficientFunds, http.StatusPaymentRequired},
+	{domain.ErrOrderNotFound, http.StatusNotFound},
+	{domain.ErrWebhookNotFound, http.StatusNotFound},
+	{domain.ErrUserExists, http.StatusConflict},
+	{domain.ErrOrderOwnedByOther, http.StatusConflict},
+	{domain.ErrWithdrawalExists, http.StatusConflict},
+	{domain.ErrWebhookExists, http.StatusConflict},
+	{domain.ErrInvalidLuhn, http.StatusUnprocessableEntity},
+	{domain.ErrUnprocessableOrder, http.StatusUnprocessableEntity},
+	{domain.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity},
+}
+
+// ErrorStatus returns the response status for err
+func ErrorStatus(err error) int {
+	for _, p := range problemStatuses {
+		if errors.Is(err, p.err) {
+			return p.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError responds with the status of err and its problem details
+func writeError(w http.ResponseWriter, err error) {
+	httpError(w, err, ErrorStatus(err))
+}
+
+// writeProblem responds with problem details, internal errors are only logged, not shown to the client
+func writeProblem(w http.ResponseWriter, err error, status int) {
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+	if status < http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+	bytes, marshalErr := json.Marshal(p)
+	if marshalErr != nil {
+		http.Error(w, p.Title, status)
+		return
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if _, writeErr := w.Write(bytes); writeErr != nil {
+		log.Printf("server:problem write error: %v", writeErr)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		detail string
+	}{
+		{
+			name:   "test#1 - Positive: wrapped domain error keeps its status",
+			err:    fmt.Errorf("%w: user200", domain.ErrUserExists),
+			status: http.StatusConflict,
+			detail: "login is occupied: user200",
+		},
+		{
+			name:   "test#2 - Positive: numbers in details do not change status",
+			err:    fmt.Errorf("%w: 4000000000000002", domain.ErrInvalidLuhn),
+			status: http.StatusUnprocessableEntity,
+			detail: "order number does not pass Luhn check: 4000000000000002",
+		},
+		{
+			name:   "test#3 - Positive: insufficient funds",
+			err:    fmt.Errorf("withdrawal of 500 on order 2377225624 %w", domain.ErrInsufficientFunds),
+			status: http.StatusPaymentRequired,
+			detail: "withdrawal of 500 on order 2377225624 insufficient funds",
+		},
+		{
+			name:   "test#4 - Negative: unknown error is internal and its details are hidden",
+			err:    errors.New("409 connection refused"),
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+			var p Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, Problem{Type: "about:blank", Title: http.StatusText(tt.status), Status: tt.status, Detail: tt.detail}, p)
+		})
+	}
+}
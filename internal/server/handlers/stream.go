@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/alphaonly/gomartv2/internal/schema"
+)
+
+const (
+	// LastEventIDHeader is sent by reconnecting SSE clients to resume the stream
+	LastEventIDHeader = "Last-Event-ID"
+	// OrderStreamEvent carries the changed order
+	OrderStreamEvent = "order"
+	// ResetStreamEvent tells the client that some updates are lost and orders have to be reloaded
+	ResetStreamEvent = "reset"
+)
+
+// streamHeartbeat keeps idle streams open through proxies
+var streamHeartbeat = 15 * time.Second
+
+func (h *Handlers) HandleGetUserOrdersStream(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandleGetUserOrdersStream invoked")
+		//Get parameters from previous handler
+		userName, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if h.Hub == nil || !ok {
+			httpError(w, errors.New("order stream is not available"), http.StatusServiceUnavailable)
+			return
+		}
+		var lastEventID int64
+		if s := r.Header.Get(LastEventIDHeader); s != "" {
+			lastEventID, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				writeError(w, fmt.Errorf("%w: %v is not an event id", domain.ErrBadRequest, s))
+				return
+			}
+		}
+		//Handling
+		subscription, missed, complete := h.Hub.Subscribe(string(userName), lastEventID)
+		defer h.Hub.Unsubscribe(subscription)
+
+		//Response
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if !complete {
+			err = writeStreamEvent(w, 0, ResetStreamEvent, struct{}{})
+		}
+		for i := 0; err == nil && i < len(missed); i++ {
+			err = writeStreamEvent(w, missed[i].ID, OrderStreamEvent, missed[i].Order)
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for err == nil {
+			select {
+			case <-r.Context().Done():
+				return
+			case u, ok := <-subscription.C:
+				if !ok {
+					//stream is dropped by the hub, the client resumes with Last-Event-ID
+					return
+				}
+				err = writeStreamEvent(w, u.ID, OrderStreamEvent, u.Order)
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			flusher.Flush()
+		}
+		log.Printf("user %v HandleGetUserOrdersStream write error: %v", userName, err)
+	}
+}
+
+// writeStreamEvent writes SSE event, id zero is not sent so that it does not move the client's Last-Event-ID
+func writeStreamEvent(w http.ResponseWriter, id int64, event string, data any) (err error) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		if _, err = fmt.Fprintf(w, "id: %v\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event, bytes)
+	return err
+}
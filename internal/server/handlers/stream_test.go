@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/alphaonly/gomartv2/internal/server/auth"
+	"github.com/alphaonly/gomartv2/internal/server/hub"
+	mem "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleGetUserOrdersStream(t *testing.T) {
+	streamHeartbeat = 50 * time.Millisecond
+	s := mem.NewMemStorage()
+	orderUpdates := hub.New(hub.DefaultBacklog, hub.DefaultBuffer)
+	h := &Handlers{Storage: s, EntityHandler: NewEntityHandler(s, auth.NewTokens("secret", time.Hour)), Hub: orderUpdates}
+	ts := httptest.NewServer(h.NewRouter())
+	defer ts.Close()
+	token, _, err := h.EntityHandler.IssueToken("user1")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connect := func(lastEventID string) (*http.Response, *bufio.Reader) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/user/orders/stream", nil)
+		assert.NoError(t, err)
+		r.Header.Set("Authorization", auth.BearerPrefix+token)
+		if lastEventID != "" {
+			r.Header.Set(LastEventIDHeader, lastEventID)
+		}
+		response, err := http.DefaultClient.Do(r)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return response, bufio.NewReader(response.Body)
+	}
+	//readEvent returns lines of the next event, heartbeats are skipped
+	readEvent := func(reader *bufio.Reader) (lines []string) {
+		for {
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return lines
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" && len(lines) > 0 {
+				return lines
+			}
+			if line != "" && !strings.HasPrefix(line, ":") {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	response, reader := connect("")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	orderUpdates.Publish(schema.Order{Order: 1, User: "user2", Status: schema.StatusProcessing})
+	orderUpdates.Publish(schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusProcessed, Accrual: 50000})
+	event := readEvent(reader)
+	if assert.Len(t, event, 3) {
+		assert.True(t, strings.HasPrefix(event[0], "id: "))
+		assert.Equal(t, "event: order", event[1])
+		assert.Equal(t, `data: {"number":12345678903,"user":"user1","status":"PROCESSED","accrual":500,"uploaded_at":"0001-01-01T00:00:00Z"}`, event[2])
+	}
+	response.Body.Close()
+
+	//resume from the received event, the update made while disconnected is sent first
+	lastEventID := strings.TrimPrefix(event[0], "id: ")
+	orderUpdates.Publish(schema.Order{Order: 2377225624, User: "user1", Status: schema.StatusInvalid})
+	response, reader = connect(lastEventID)
+	event = readEvent(reader)
+	if assert.Len(t, event, 3) {
+		assert.Contains(t, event[2], `"number":2377225624`)
+	}
+	response.Body.Close()
+
+	//unknown id asks to reload orders
+	response, reader = connect("1")
+	assert.Equal(t, []string{"event: reset", "data: {}"}, readEvent(reader))
+	response.Body.Close()
+
+	response, _ = connect("x")
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response.Body.Close()
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+)
+
+func (h *Handlers) HandleGetUserTierHistory(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandleGetUserTierHistory invoked")
+		//Get parameters from previous handler
+		userName, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		//Handling
+		changes, err := h.EntityHandler.GetTierHistory(r.Context(), string(userName))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(changes) == 0 {
+			log.Printf("no tier changes for user %v", userName)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		//Response
+		bytes, err := json.Marshal(changes)
+		if err != nil {
+			httpErrorW(w, fmt.Sprintf("user %v tier history json marshal error", userName), err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(bytes)
+		if err != nil {
+			log.Printf("user %v HandleGetUserTierHistory write response error: %v", userName, err)
+		}
+	}
+}
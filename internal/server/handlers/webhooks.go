@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/go-chi/chi/v5"
+)
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+// WebhookResponse shows the signing secret only once, when the webhook is created
+type WebhookResponse struct {
+	schema.Webhook
+	Secret string `json:"secret"`
+}
+
+func (h *Handlers) HandlePostUserWebhook(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandlePostUserWebhook invoked")
+		//Get parameters from previous handler
+		userName, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		if h.Webhooks == nil {
+			httpError(w, errors.New("webhooks are not available"), http.StatusServiceUnavailable)
+			return
+		}
+		//Handling
+		requestByteData, err := io.ReadAll(r.Body)
+		if err != nil {
+			httpErrorW(w, "unrecognized json request", err, http.StatusBadRequest)
+			return
+		}
+		request := WebhookRequest{}
+		err = json.Unmarshal(requestByteData, &request)
+		if err != nil {
+			writeError(w, fmt.Errorf("%w: %v", domain.ErrBadRequest, err))
+			return
+		}
+		webhook, err := h.EntityHandler.CreateWebhook(r.Context(), string(userName), request.URL)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		//Response
+		bytes, err := json.Marshal(WebhookResponse{Webhook: *webhook, Secret: h.Webhooks.Secret(webhook.ID)})
+		if err != nil {
+			httpErrorW(w, fmt.Sprintf("user %v webhook json marshal error", userName), err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, err = w.Write(bytes)
+		if err != nil {
+			log.Printf("user %v HandlePostUserWebhook write response error: %v", userName, err)
+		}
+	}
+}
+
+func (h *Handlers) HandleGetUserWebhooks(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandleGetUserWebhooks invoked")
+		//Get parameters from previous handler
+		userName, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		//Handling
+		webhooks, err := h.EntityHandler.GetWebhooks(r.Context(), string(userName))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(webhooks) == 0 {
+			log.Printf("no webhooks for user %v", userName)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		//Response
+		bytes, err := json.Marshal(webhooks)
+		if err != nil {
+			httpErrorW(w, fmt.Sprintf("user %v webhooks json marshal error", userName), err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(bytes)
+		if err != nil {
+			log.Printf("user %v HandleGetUserWebhooks write response error: %v", userName, err)
+		}
+	}
+}
+
+func (h *Handlers) HandleDeleteUserWebhook(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandleDeleteUserWebhook invoked")
+		//Get parameters from previous handler
+		userName, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		//Handling
+		err = h.EntityHandler.DeleteWebhook(r.Context(), string(userName), chi.URLParam(r, "id"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		//Response
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handlers) HandleGetUserWebhookDeliveries(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HandleGetUserWebhookDeliveries invoked")
+		//Get parameters from previous handler
+		userName, err := getPreviousParameter[schema.CtxUName, schema.ContextKey](r, schema.CtxKeyUName)
+		if err != nil {
+			httpError(w, fmt.Errorf("cannot get userName from context %w", err), http.StatusInternalServerError)
+			return
+		}
+		q, err := parseListQuery(r, true, false)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		//Handling
+		deliveries, next, err := h.EntityHandler.GetWebhookDeliveries(r.Context(), string(userName), q)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(deliveries) == 0 {
+			log.Printf("no webhook deliveries for user %v", userName)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		//Response
+		bytes, err := json.Marshal(deliveries)
+		if err != nil {
+			httpErrorW(w, fmt.Sprintf("user %v webhook deliveries json marshal error", userName), err, http.StatusInternalServerError)
+			return
+		}
+		setNextPage(w, r, next)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(bytes)
+		if err != nil {
+			log.Printf("user %v HandleGetUserWebhookDeliveries write response error: %v", userName, err)
+		}
+	}
+}
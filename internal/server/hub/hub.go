@@ -0,0 +1,149 @@
+// Package hub fans out order updates made by accrual checker to the streams of their users.
+// Recent updates are kept in a backlog, so that a reconnected stream resumes from its Last-Event-ID.
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+)
+
+// Update is a change of status or accrual of the user's order
+type Update struct {
+	//ID grows with every update, ids of the previous process are older than any of the current one
+	ID    int64
+	Order schema.Order
+}
+
+// Subscription receives updates of one user until it is closed by Unsubscribe, by Close of the hub
+// or because the subscriber fell behind, then the client has to reconnect with the last received id
+type Subscription struct {
+	C    <-chan Update
+	c    chan Update
+	user string
+}
+
+const (
+	// DefaultBacklog is enough to resume streams after a short reconnect of a busy instance
+	DefaultBacklog = 1000
+	// DefaultBuffer is how many updates a stream may be behind before it is dropped
+	DefaultBuffer = 16
+)
+
+type Hub struct {
+	mu          sync.Mutex
+	lastID      int64
+	backlog     []Update //ring of the latest updates of all users
+	next        int      //backlog position of the next update
+	buffer      int
+	subscribers map[string]map[*Subscription]struct{}
+	closed      bool
+}
+
+// New creates hub remembering backlog latest updates, buffer is how many updates a subscriber may fall behind
+func New(backlog int, buffer int) *Hub {
+	if backlog < 1 {
+		backlog = 1
+	}
+	if buffer < 1 {
+		buffer = 1
+	}
+	return &Hub{
+		lastID:      time.Now().UnixMicro(),
+		backlog:     make([]Update, 0, backlog),
+		buffer:      buffer,
+		subscribers: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends the order update to the streams of the order's user
+func (h *Hub) Publish(o schema.Order) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	u := Update{ID: h.lastID, Order: o}
+	if len(h.backlog) < cap(h.backlog) {
+		h.backlog = append(h.backlog, u)
+	} else {
+		h.backlog[h.next] = u
+	}
+	h.next = (h.next + 1) % cap(h.backlog)
+
+	for s := range h.subscribers[o.User] {
+		select {
+		case s.c <- u:
+		default:
+			//a slow stream must not hold the checker, it resumes from the backlog after reconnect
+			h.remove(s)
+		}
+	}
+}
+
+// Subscribe starts receiving updates of the user. With lastEventID other than zero the updates after it
+// are returned as missed, complete is false if some of them are already out of the backlog
+// or lastEventID is unknown, then the client has to reload its orders.
+func (h *Hub) Subscribe(user string, lastEventID int64) (s *Subscription, missed []Update, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := make(chan Update, h.buffer)
+	s = &Subscription{C: c, c: c, user: user}
+	if h.closed {
+		close(c)
+		return s, nil, false
+	}
+	if h.subscribers[user] == nil {
+		h.subscribers[user] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[user][s] = struct{}{}
+
+	if lastEventID == 0 {
+		return s, nil, true
+	}
+	oldest := h.lastID + 1
+	for i := 0; i < len(h.backlog); i++ {
+		u := h.backlog[(h.next+i)%len(h.backlog)]
+		if u.ID < oldest {
+			oldest = u.ID
+		}
+		if u.ID > lastEventID && u.Order.User == user {
+			missed = append(missed, u)
+		}
+	}
+	complete = lastEventID <= h.lastID && lastEventID >= oldest-1
+	return s, missed, complete
+}
+
+// Unsubscribe stops the subscription, it is safe to call it more than once
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+// Close ends all subscriptions, new ones are closed at once
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subscriptions := range h.subscribers {
+		for s := range subscriptions {
+			h.remove(s)
+		}
+	}
+}
+
+func (h *Hub) remove(s *Subscription) {
+	subscriptions := h.subscribers[s.user]
+	if _, ok := subscriptions[s]; !ok {
+		return
+	}
+	delete(subscriptions, s)
+	if len(subscriptions) == 0 {
+		delete(h.subscribers, s.user)
+	}
+	close(s.c)
+}
@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func order(number int64, user string, status schema.OrderStatus) schema.Order {
+	return schema.Order{Order: number, User: user, Status: status}
+}
+
+func TestHubFanOut(t *testing.T) {
+	h := New(10, 10)
+	alice1, _, _ := h.Subscribe("alice", 0)
+	alice2, _, _ := h.Subscribe("alice", 0)
+	bob, _, _ := h.Subscribe("bob", 0)
+
+	h.Publish(order(1, "alice", schema.StatusProcessing))
+
+	for _, s := range []*Subscription{alice1, alice2} {
+		u := <-s.C
+		assert.Equal(t, int64(1), u.Order.Order)
+	}
+	assert.Len(t, bob.C, 0)
+
+	h.Unsubscribe(alice2)
+	h.Unsubscribe(alice2)
+	_, ok := <-alice2.C
+	assert.False(t, ok)
+
+	h.Close()
+	_, ok = <-alice1.C
+	assert.False(t, ok)
+	_, ok = <-bob.C
+	assert.False(t, ok)
+	closed, _, complete := h.Subscribe("alice", 0)
+	_, ok = <-closed.C
+	assert.False(t, ok)
+	assert.False(t, complete)
+}
+
+func TestHubResume(t *testing.T) {
+	h := New(3, 10)
+	s, _, _ := h.Subscribe("alice", 0)
+	h.Publish(order(1, "alice", schema.StatusProcessing))
+	h.Publish(order(2, "bob", schema.StatusProcessing))
+	h.Publish(order(1, "alice", schema.StatusProcessed))
+	first := <-s.C
+	h.Unsubscribe(s)
+
+	tests := []struct {
+		name        string
+		lastEventID int64
+		orders      []int64
+		complete    bool
+	}{
+		{name: "test#1 - Positive: updates after the last received", lastEventID: first.ID, orders: []int64{1}, complete: true},
+		{name: "test#2 - Positive: up to date", lastEventID: first.ID + 2, complete: true},
+		{name: "test#3 - Negative: id of another process", lastEventID: first.ID + 100, complete: false},
+		{name: "test#4 - Negative: id before the backlog", lastEventID: 1, orders: []int64{1, 1}, complete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, missed, complete := h.Subscribe("alice", tt.lastEventID)
+			defer h.Unsubscribe(s)
+			var orders []int64
+			for _, u := range missed {
+				orders = append(orders, u.Order.Order)
+			}
+			assert.Equal(t, tt.orders, orders)
+			assert.Equal(t, tt.complete, complete)
+		})
+	}
+
+	//the update following the last received one is out of the backlog of three
+	h.Publish(order(3, "alice", schema.StatusProcessing))
+	h.Publish(order(4, "alice", schema.StatusProcessing))
+	s, missed, complete := h.Subscribe("alice", first.ID)
+	defer h.Unsubscribe(s)
+	assert.False(t, complete)
+	assert.Len(t, missed, 3)
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := New(10, 1)
+	s, _, _ := h.Subscribe("alice", 0)
+	h.Publish(order(1, "alice", schema.StatusProcessing))
+	h.Publish(order(1, "alice", schema.StatusProcessed))
+
+	u, ok := <-s.C
+	assert.True(t, ok)
+	assert.Equal(t, schema.StatusProcessing, u.Order.Status)
+	_, ok = <-s.C
+	assert.False(t, ok, "subscriber behind the buffer is dropped")
+}
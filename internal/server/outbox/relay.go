@@ -0,0 +1,151 @@
+// Package outbox delivers domain events saved by storage in the transactions of their changes.
+// Delivery is at least once: a message is removed only after every sink accepted it, so a sink may get
+// a message again after a failure of another sink or a crash, and has to deduplicate by the message id.
+// Messages of a user reach sinks in the order of the changes.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	storage "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+)
+
+// Sink receives outbox messages, an error makes the relay send the message again later
+type Sink interface {
+	Send(ctx context.Context, m schema.OutboxMessage) error
+}
+
+type Relay struct {
+	storage  storage.Storage
+	sinks    []Sink
+	owner    string
+	interval time.Duration
+	lease    time.Duration
+	batch    int
+	minRetry time.Duration
+	maxRetry time.Duration
+}
+
+// NewRelay creates relay of outbox messages to sinks
+func NewRelay(storage storage.Storage, sinks ...Sink) *Relay {
+	return &Relay{
+		storage:  storage,
+		sinks:    sinks,
+		owner:    newOwnerID(),
+		interval: time.Second,
+		lease:    time.Minute,
+		batch:    100,
+		minRetry: time.Second,
+		maxRetry: 5 * time.Minute,
+	}
+}
+
+// newOwnerID identifies the relay of this instance in message leases
+func newOwnerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal("cannot generate outbox relay id")
+	}
+	return fmt.Sprintf("%v-%v-%v", host, os.Getpid(), hex.EncodeToString(id))
+}
+
+// Run relays messages until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.relay(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// relay sends claimed messages, users are served concurrently and messages of one user one by one
+func (r *Relay) relay(ctx context.Context) {
+	ml, err := r.storage.ClaimOutbox(ctx, r.owner, r.lease, r.batch)
+	if err != nil {
+		log.Printf("can not claim outbox messages: %v", err)
+		return
+	}
+	var users []string
+	byUser := make(map[string][]schema.OutboxMessage)
+	for _, m := range ml {
+		if byUser[m.User] == nil {
+			users = append(users, m.User)
+		}
+		byUser[m.User] = append(byUser[m.User], m)
+	}
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(ml []schema.OutboxMessage) {
+			defer wg.Done()
+			for _, m := range ml {
+				if !r.deliver(ctx, m) {
+					//the rest of the user's messages are released by the retry and wait for it
+					return
+				}
+			}
+		}(byUser[user])
+	}
+	wg.Wait()
+}
+
+// deliver sends m to all sinks and tells whether it is acknowledged
+func (r *Relay) deliver(ctx context.Context, m schema.OutboxMessage) (ok bool) {
+	err := r.send(ctx, m)
+	//the outcome is saved even if relay is stopping, otherwise the message waits for the lease
+	saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err == nil {
+		if err = r.storage.AckOutboxMessage(saveCtx, m.ID, r.owner); err != nil {
+			log.Printf("unable to acknowledge outbox message %v: %v", m.ID, err)
+			return false
+		}
+		return true
+	}
+	m.Attempts++
+	m.LastError = err.Error()
+	m.NextAttempt = schema.CreatedTime(time.Now().Add(r.retryAfter(m.Attempts)))
+	log.Printf("outbox message %v attempt %v failed: %v", m.ID, m.Attempts, err)
+	if err = r.storage.RetryOutboxMessage(saveCtx, m, r.owner); err != nil {
+		log.Printf("unable to save outbox message %v: %v", m.ID, err)
+	}
+	return false
+}
+
+func (r *Relay) send(ctx context.Context, m schema.OutboxMessage) error {
+	for _, sink := range r.sinks {
+		if err := sink.Send(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryAfter doubles the pause with every failed attempt, messages are never dropped
+func (r *Relay) retryAfter(attempts int) time.Duration {
+	pause := r.minRetry
+	for i := 1; i < attempts && pause < r.maxRetry; i++ {
+		pause *= 2
+	}
+	if pause > r.maxRetry {
+		pause = r.maxRetry
+	}
+	return pause
+}
@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+	mem "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink remembers orders of the messages it got by user and fails the first send of failOrder
+type recordingSink struct {
+	mu        sync.Mutex
+	failOrder int64
+	failed    bool
+	got       map[string][]int64
+}
+
+func (r *recordingSink) Send(ctx context.Context, m schema.OutboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m.Order == r.failOrder && !r.failed {
+		r.failed = true
+		return errors.New("sink is down")
+	}
+	if r.got == nil {
+		r.got = make(map[string][]int64)
+	}
+	r.got[m.User] = append(r.got[m.User], m.Order)
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	for _, user := range []string{"alice", "bob"} {
+		assert.NoError(t, s.SaveUser(ctx, &schema.User{User: user, Password: "password"}))
+	}
+	now := schema.CreatedTime(time.Now())
+	assert.NoError(t, s.SaveOrder(ctx, schema.Order{Order: 1, User: "alice", Status: schema.StatusNew, Created: now}))
+	assert.NoError(t, s.SaveOrder(ctx, schema.Order{Order: 2, User: "alice", Status: schema.StatusNew, Created: now}))
+	assert.NoError(t, s.SaveOrder(ctx, schema.Order{Order: 3, User: "bob", Status: schema.StatusNew, Created: now}))
+
+	first := &recordingSink{}
+	flaky := &recordingSink{failOrder: 1}
+	r := NewRelay(s, first, flaky)
+	r.minRetry = 0
+
+	r.relay(ctx)
+	//alice's messages wait for the failed one, bob's are not held
+	assert.Equal(t, map[string][]int64{"bob": {3}}, flaky.got)
+
+	r.relay(ctx)
+	assert.Equal(t, map[string][]int64{"alice": {1, 2}, "bob": {3}}, flaky.got)
+	//at least once: the sink before the failed one got the message again
+	assert.Equal(t, map[string][]int64{"alice": {1, 1, 2}, "bob": {3}}, first.got)
+
+	ml, err := s.ClaimOutbox(ctx, "test", time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, ml)
+}
+
+func TestRetryAfter(t *testing.T) {
+	r := &Relay{minRetry: time.Second, maxRetry: 5 * time.Second}
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{name: "test#1 - Positive: first retry", attempts: 1, want: time.Second},
+		{name: "test#2 - Positive: doubled", attempts: 3, want: 4 * time.Second},
+		{name: "test#3 - Positive: limited", attempts: 20, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, r.retryAfter(tt.attempts))
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	sink, err := NewFileSink(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	m, err := schema.NewOutboxMessage("alice", schema.TopicOrderUploaded, 1, schema.Order{Order: 1, User: "alice"}, schema.CreatedTime(time.Now()))
+	assert.NoError(t, err)
+	m.ID = 7
+	assert.NoError(t, sink.Send(context.Background(), m))
+	assert.NoError(t, sink.Send(context.Background(), m))
+	assert.NoError(t, sink.Close())
+
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+		var got schema.OutboxMessage
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &got))
+		assert.Equal(t, int64(7), got.ID)
+		assert.Equal(t, schema.TopicOrderUploaded, got.Topic)
+		assert.JSONEq(t, string(m.Payload), string(got.Payload))
+	}
+	assert.Equal(t, 2, lines)
+}
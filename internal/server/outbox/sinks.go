@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/alphaonly/gomartv2/internal/schema"
+)
+
+// LogSink writes messages to the service log
+type LogSink struct{}
+
+func (LogSink) Send(ctx context.Context, m schema.OutboxMessage) error {
+	log.Printf("outbox: message %v %v of user %v: %s", m.ID, m.Topic, m.User, m.Payload)
+	return nil
+}
+
+// FileSink appends messages to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens the file for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Send returns after the message is synced to disk
+func (f *FileSink) Send(ctx context.Context, m schema.OutboxMessage) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err = f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *FileSink) Close() error {
+	return f.file.Close()
+}
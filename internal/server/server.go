@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/alphaonly/gomartv2/internal/server/accrual"
+	"github.com/alphaonly/gomartv2/internal/server/outbox"
 
 	conf "github.com/alphaonly/gomartv2/internal/configuration"
 	"github.com/alphaonly/gomartv2/internal/server/handlers"
@@ -26,6 +27,7 @@ type Server struct {
 	handlers        *handlers.Handlers
 	httpServer      *http.Server
 	AccrualChecker  *accrual.Checker
+	OutboxRelay     *outbox.Relay
 }
 
 func NewConfiguration(serverPort string) *Configuration {
@@ -36,13 +38,15 @@ func New(
 	configuration *conf.ServerConfiguration,
 	ExStorage stor.Storage,
 	handlers *handlers.Handlers,
-	accrualChecker *accrual.Checker) (server Server) {
+	accrualChecker *accrual.Checker,
+	outboxRelay *outbox.Relay) (server Server) {
 	return Server{
 		configuration:   configuration,
 		InternalStorage: handlers.Storage,
 		ExternalStorage: ExStorage,
 		handlers:        handlers,
 		AccrualChecker:  accrualChecker,
+		OutboxRelay:     outboxRelay,
 	}
 }
 
@@ -61,14 +65,60 @@ func (s *Server) Run(ctx context.Context) error {
 		Addr:    s.configuration.RunAddress,
 		Handler: s.handlers.NewRouter(),
 	}
+	//open streams would hold shutdown until its context is done
+	if s.handlers.Hub != nil {
+		s.httpServer.RegisterOnShutdown(s.handlers.Hub.Close)
+	}
 
 	go s.ListenData(ctx)
-	go s.AccrualChecker.Run(ctx)
+
+	checkerCtx, stopChecker := context.WithCancel(ctx)
+	checkerDone := make(chan struct{})
+	go func() {
+		defer close(checkerDone)
+		s.AccrualChecker.Run(checkerCtx)
+	}()
+
+	dispatcherCtx, stopDispatcher := context.WithCancel(ctx)
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		if s.handlers.Webhooks != nil {
+			s.handlers.Webhooks.Run(dispatcherCtx)
+		}
+	}()
+
+	relayCtx, stopRelay := context.WithCancel(ctx)
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		if s.OutboxRelay != nil {
+			s.OutboxRelay.Run(relayCtx)
+		}
+	}()
+
+	expiryCtx, stopExpiry := context.WithCancel(ctx)
+	expiryDone := make(chan struct{})
+	go func() {
+		defer close(expiryDone)
+		s.RunPointsExpiry(expiryCtx)
+	}()
 
 	osSignal := make(chan os.Signal, 1)
 	signal.Notify(osSignal, os.Interrupt)
 
 	<-osSignal
+	//let accrual workers finish their current requests
+	stopChecker()
+	<-checkerDone
+	//deliveries queued by the checker stay in storage for the next start
+	stopDispatcher()
+	<-dispatcherDone
+	//undelivered messages stay in the outbox for the next start
+	stopRelay()
+	<-relayDone
+	stopExpiry()
+	<-expiryDone
 	err := s.Shutdown(ctx)
 
 	return err
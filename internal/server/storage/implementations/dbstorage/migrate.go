@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//Schema is changed only by migrations/NNNN_name.up.sql files, each with its NNNN_name.down.sql pair.
+//Applied versions are kept in schema_migrations table.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of advisory lock held while migrations are applied,
+// so that replicas starting at the same time do not race
+const migrationLockID int64 = 0x676f6d617274 //"gomart"
+
+const (
+	createSchemaMigrationsTable = `CREATE TABLE IF NOT EXISTS public.schema_migrations
+	(	version 		integer 		not null primary key,
+		name 			TEXT 			not null,
+		applied_at 		timestamptz 	not null default now()
+	);`
+	selectSchemaVersion    = `SELECT coalesce(max(version), 0) FROM public.schema_migrations;`
+	insertSchemaMigration  = `INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2);`
+	deleteSchemaMigration  = `DELETE FROM public.schema_migrations WHERE version = $1;`
+	lockSchemaMigrations   = `SELECT pg_advisory_lock($1);`
+	unlockSchemaMigrations = `SELECT pg_advisory_unlock($1);`
+)
+
+var ErrUnknownVersion = errors.New("database schema version is unknown to this build")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+	ownPool    bool
+}
+
+// NewMigrator connects to database for migrate command, Close releases the connection
+func NewMigrator(ctx context.Context, dataBaseURL string) (*Migrator, error) {
+	pool, err := pgxpool.New(ctx, dataBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", message[0], err)
+	}
+	m, err := newMigrator(pool)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	m.ownPool = true
+	return m, nil
+}
+
+func newMigrator(pool *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+func (m *Migrator) Close() {
+	if m.ownPool {
+		m.pool.Close()
+	}
+}
+
+// Latest is the version Up brings the schema to
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the last applied migration, 0 for an empty database
+func (m *Migrator) Version(ctx context.Context) (version int, err error) {
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		version, err = schemaVersion(ctx, conn)
+		return err
+	})
+	return version, err
+}
+
+// Up applies all pending migrations, each one in its own transaction
+func (m *Migrator) Up(ctx context.Context) (applied int, err error) {
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		version, err := schemaVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: %v, latest known is %v", ErrUnknownVersion, version, m.Latest())
+		}
+		for _, mg := range m.migrations {
+			if mg.Version <= version {
+				continue
+			}
+			err = apply(ctx, conn, mg.Up, insertSchemaMigration, mg.Version, mg.Name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%v up: %w", mg.Version, mg.Name, err)
+			}
+			log.Printf("DBStorage:migration %04d_%v applied", mg.Version, mg.Name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) (reverted int, err error) {
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		version, err := schemaVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: %v, latest known is %v", ErrUnknownVersion, version, m.Latest())
+		}
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mg := m.migrations[i]
+			if mg.Version > version {
+				continue
+			}
+			err = apply(ctx, conn, mg.Down, deleteSchemaMigration, mg.Version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%v down: %w", mg.Version, mg.Name, err)
+			}
+			log.Printf("DBStorage:migration %04d_%v reverted", mg.Version, mg.Name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// locked runs f on a dedicated connection holding the migrations advisory lock
+func (m *Migrator) locked(ctx context.Context, f func(conn *pgxpool.Conn) error) (err error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%v: %w", message[0], err)
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, lockSchemaMigrations, migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		//the lock is bound to the session, so it must be released before the connection returns to the pool
+		if _, unlockErr := conn.Exec(context.Background(), unlockSchemaMigrations, migrationLockID); unlockErr != nil {
+			log.Printf("DBStorage:unable to release migrations lock: %v", unlockErr)
+		}
+	}()
+
+	if _, err = conn.Exec(ctx, createSchemaMigrationsTable); err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+func schemaVersion(ctx context.Context, conn *pgxpool.Conn) (version int, err error) {
+	err = conn.QueryRow(ctx, selectSchemaVersion).Scan(&version)
+	return version, err
+}
+
+// apply executes migration script and records it in schema_migrations within one transaction
+func apply(ctx context.Context, conn *pgxpool.Conn, script string, record string, args ...any) (err error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf(message[7], err)
+	}
+	defer tx.Rollback(ctx)
+
+	//without arguments the script goes by simple protocol, so it may hold several statements
+	if _, err = tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// loadMigrations reads NNNN_name.up.sql and NNNN_name.down.sql pairs ordered by version
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := cutDirection(fileName)
+		if !ok {
+			return nil, fmt.Errorf("migration file %v: name must end with .up.sql or .down.sql", fileName)
+		}
+		number, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("migration file %v: name must look like 0001_name", fileName)
+		}
+		script, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: name}
+			byVersion[version] = mg
+		}
+		if mg.Name != name {
+			return nil, fmt.Errorf("migration %04d has different names %v and %v", version, mg.Name, name)
+		}
+		if direction == "up" {
+			mg.Up = string(script)
+		} else {
+			mg.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%v must have both up and down scripts", mg.Version, mg.Name)
+		}
+		migrations = append(migrations, *mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, mg := range migrations {
+		if mg.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+func cutDirection(fileName string) (base string, direction string, ok bool) {
+	for _, direction = range []string{"up", "down"} {
+		suffix := "." + direction + ".sql"
+		if strings.HasSuffix(fileName, suffix) {
+			return strings.TrimSuffix(fileName, suffix), direction, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		wantErr  bool
+	}{
+		{
+			name: "test#1 - Positive: pairs are ordered by version",
+			files: fstest.MapFS{
+				"migrations/0002_second.up.sql":   file,
+				"migrations/0002_second.down.sql": file,
+				"migrations/0001_first.up.sql":    file,
+				"migrations/0001_first.down.sql":  file,
+			},
+			versions: []int{1, 2},
+		},
+		{
+			name: "test#2 - Negative: down script is missing",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql": file,
+			},
+			wantErr: true,
+		},
+		{
+			name: "test#3 - Negative: version gap",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql":   file,
+				"migrations/0001_first.down.sql": file,
+				"migrations/0003_third.up.sql":   file,
+				"migrations/0003_third.down.sql": file,
+			},
+			wantErr: true,
+		},
+		{
+			name: "test#4 - Negative: bad file name",
+			files: fstest.MapFS{
+				"migrations/first.up.sql": file,
+			},
+			wantErr: true,
+		},
+		{
+			name: "test#5 - Negative: the same version with different names",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql":   file,
+				"migrations/0001_other.down.sql": file,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "migrations")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			var versions []int
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			assert.Equal(t, tt.versions, versions)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+}
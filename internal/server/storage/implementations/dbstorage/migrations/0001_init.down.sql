@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.revoked_tokens;
+DROP TABLE IF EXISTS public.ledger_entries;
+DROP TABLE IF EXISTS public.orders;
+DROP TABLE IF EXISTS public.users;
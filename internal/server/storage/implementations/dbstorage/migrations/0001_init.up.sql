@@ -0,0 +1,39 @@
+-- tables as they were created by NewDBStorage before migrations,
+-- IF NOT EXISTS lets existing databases adopt the baseline as is
+CREATE TABLE IF NOT EXISTS public.users
+(	user_id 		varchar(40) 	not null primary key,
+	password 		TEXT 			not null,
+	accrual 		bigint,
+	withdrawal 		bigint
+);
+
+CREATE TABLE IF NOT EXISTS public.orders
+(	order_id 		integer 		not null,
+	user_id 		varchar(40) 	not null,
+	status 			integer,
+	accrual 		bigint,
+	uploaded_at 	TEXT 			not null,
+	lease_owner 	varchar(64),
+	lease_until 	timestamptz,
+	primary key (order_id, user_id)
+);
+
+-- orders tables created before leases existed
+ALTER TABLE public.orders
+	ADD COLUMN IF NOT EXISTS lease_owner varchar(64),
+	ADD COLUMN IF NOT EXISTS lease_until timestamptz;
+
+CREATE TABLE IF NOT EXISTS public.ledger_entries
+(	entry_id 		bigserial 		primary key,
+	user_id 		varchar(40) 	not null references public.users (user_id),
+	entry_type 		varchar(20) 	not null,
+	amount 			bigint 			not null,
+	order_id 		bigint,
+	created_at 		timestamptz 	not null,
+	unique (order_id, entry_type)
+);
+
+CREATE TABLE IF NOT EXISTS public.revoked_tokens
+(	token_id 		varchar(64) 	not null primary key,
+	expires_at 		timestamptz 	not null
+);
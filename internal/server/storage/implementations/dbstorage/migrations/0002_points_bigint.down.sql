@@ -0,0 +1,3 @@
+-- points stay bigint hundredths, it is the baseline type of the columns.
+-- Order numbers above integer range make the rollback fail instead of being truncated.
+ALTER TABLE public.orders ALTER COLUMN order_id TYPE integer;
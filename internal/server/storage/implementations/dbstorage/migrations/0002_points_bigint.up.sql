@@ -0,0 +1,20 @@
+-- the first tables kept points as double precision of whole points,
+-- now they are bigint hundredths of a point
+DO $$
+BEGIN
+	IF (SELECT data_type FROM information_schema.columns
+		WHERE table_schema = 'public' AND table_name = 'users' AND column_name = 'accrual') = 'double precision' THEN
+		ALTER TABLE public.users
+			ALTER COLUMN accrual TYPE bigint USING round(accrual * 100)::bigint,
+			ALTER COLUMN withdrawal TYPE bigint USING round(withdrawal * 100)::bigint;
+	END IF;
+	IF (SELECT data_type FROM information_schema.columns
+		WHERE table_schema = 'public' AND table_name = 'orders' AND column_name = 'accrual') = 'double precision' THEN
+		ALTER TABLE public.orders
+			ALTER COLUMN accrual TYPE bigint USING round(accrual * 100)::bigint;
+	END IF;
+END
+$$;
+
+-- Luhn order numbers do not fit into integer
+ALTER TABLE public.orders ALTER COLUMN order_id TYPE bigint;
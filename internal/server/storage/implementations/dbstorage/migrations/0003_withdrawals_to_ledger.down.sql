@@ -0,0 +1,14 @@
+-- ledger entries are kept, the withdrawals table is only restored for older versions to read.
+-- Its key is not user_id any more, the old table could not hold every withdrawal.
+CREATE TABLE IF NOT EXISTS public.withdrawals
+(	entry_id 		bigint 				primary key,
+	user_id 		varchar(40) 		not null,
+	uploaded_at 	TEXT 				not null,
+	withdrawal 		double precision 	not null
+);
+
+INSERT INTO public.withdrawals (entry_id, user_id, uploaded_at, withdrawal)
+SELECT entry_id, user_id, to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), -amount / 100.0
+FROM public.ledger_entries
+WHERE entry_type = 'withdrawal'
+ON CONFLICT (entry_id) DO NOTHING;
@@ -0,0 +1,22 @@
+-- the first withdrawals table had user_id as primary key, so it kept only one withdrawal per user.
+-- Its rows are moved to the ledger, and the difference between cached balance and the ledger
+-- becomes an opening adjustment, so that balances calculated from the ledger stay the same.
+DO $$
+BEGIN
+	IF to_regclass('public.withdrawals') IS NOT NULL THEN
+		INSERT INTO public.ledger_entries (user_id, entry_type, amount, order_id, created_at)
+		SELECT w.user_id, 'withdrawal', -round(w.withdrawal * 100)::bigint, NULL, w.uploaded_at::timestamptz
+		FROM public.withdrawals w
+		JOIN public.users u ON u.user_id = w.user_id;
+
+		INSERT INTO public.ledger_entries (user_id, entry_type, amount, order_id, created_at)
+		SELECT u.user_id, 'adjustment', coalesce(u.accrual, 0) - coalesce(l.total, 0), NULL, now()
+		FROM public.users u
+		LEFT JOIN (SELECT user_id, sum(amount) AS total FROM public.ledger_entries GROUP BY user_id) l
+			ON l.user_id = u.user_id
+		WHERE coalesce(u.accrual, 0) <> coalesce(l.total, 0);
+
+		DROP TABLE public.withdrawals;
+	END IF;
+END
+$$;
@@ -0,0 +1,11 @@
+-- successful withdrawals by the client's Idempotency-Key, retries are replayed instead of debiting again
+CREATE TABLE public.idempotency_keys
+(	user_id 		varchar(40) 	not null references public.users (user_id),
+	idempotency_key varchar(255) 	not null,
+	order_id 		bigint,
+	amount 			bigint 			not null,
+	created_at 		timestamptz 	not null default now(),
+	primary key (user_id, idempotency_key)
+);
+
+CREATE INDEX idempotency_keys_created_at ON public.idempotency_keys (created_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.ledger_entries_user_type_created_at;
+DROP INDEX IF EXISTS public.orders_user_uploaded_at;
+
+ALTER TABLE public.orders
+	ALTER COLUMN uploaded_at TYPE TEXT USING to_char(uploaded_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
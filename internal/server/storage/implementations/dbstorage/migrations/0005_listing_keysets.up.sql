@@ -0,0 +1,7 @@
+-- upload time was RFC3339 text, listings filter and page by it
+ALTER TABLE public.orders
+	ALTER COLUMN uploaded_at TYPE timestamptz USING uploaded_at::timestamptz;
+
+-- keyset pagination of user's orders and withdrawals
+CREATE INDEX orders_user_uploaded_at ON public.orders (user_id, uploaded_at, order_id);
+CREATE INDEX ledger_entries_user_type_created_at ON public.ledger_entries (user_id, entry_type, created_at, entry_id);
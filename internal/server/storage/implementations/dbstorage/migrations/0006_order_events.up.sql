@@ -0,0 +1,16 @@
+-- order history: upload, accrual system poll results and credit of the accrual
+CREATE TABLE public.order_events
+(	event_id 	bigserial 		primary key,
+	order_id 	bigint 			not null,
+	event_type 	varchar(20) 	not null,
+	status 		integer 		not null default 0,
+	accrual 	bigint 			not null default 0,
+	detail 		text 			not null default '',
+	created_at 	timestamptz 	not null default now()
+);
+
+CREATE INDEX order_events_order_created_at ON public.order_events (order_id, created_at, event_id);
+
+-- orders uploaded before the history have at least their upload in it
+INSERT INTO public.order_events (order_id, event_type, status, created_at)
+SELECT order_id, 'uploaded', 1, uploaded_at FROM public.orders;
@@ -0,0 +1,29 @@
+-- users' subscriptions to order status changes
+CREATE TABLE public.webhooks
+(	webhook_id 	bigserial 		primary key,
+	user_id 	varchar(40) 	not null references public.users (user_id),
+	url 		text 			not null,
+	created_at 	timestamptz 	not null default now(),
+	unique (user_id, url)
+);
+
+-- events queued for webhooks, pending ones are retried until delivered or failed
+CREATE TABLE public.webhook_deliveries
+(	delivery_id 	bigserial 		primary key,
+	webhook_id 		bigint 			not null references public.webhooks (webhook_id) on delete cascade,
+	user_id 		varchar(40) 	not null,
+	order_id 		bigint 			not null,
+	event 			varchar(40) 	not null,
+	payload 		text 			not null,
+	status 			varchar(20) 	not null default 'pending',
+	attempts 		integer 		not null default 0,
+	response_status integer 		not null default 0,
+	last_error 		text 			not null default '',
+	next_attempt_at timestamptz 	not null default now(),
+	lease_owner 	varchar(64),
+	lease_until 	timestamptz,
+	created_at 		timestamptz 	not null default now()
+);
+
+CREATE INDEX webhook_deliveries_due ON public.webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_user_created_at ON public.webhook_deliveries (user_id, created_at, delivery_id);
@@ -0,0 +1,17 @@
+-- domain events written in the transaction of the change they tell about,
+-- the relay removes a message once every sink has accepted it
+CREATE TABLE public.outbox
+(	message_id 		bigserial 		primary key,
+	user_id 		varchar(40) 	not null,
+	topic 			varchar(40) 	not null,
+	order_id 		bigint,
+	payload 		text 			not null,
+	attempts 		integer 		not null default 0,
+	last_error 		text 			not null default '',
+	next_attempt_at timestamptz 	not null default now(),
+	lease_owner 	varchar(64),
+	lease_until 	timestamptz,
+	created_at 		timestamptz 	not null default now()
+);
+
+CREATE INDEX outbox_user_message ON public.outbox (user_id, message_id);
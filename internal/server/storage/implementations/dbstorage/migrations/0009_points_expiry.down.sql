@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.ledger_entries_expiring;
+DROP INDEX IF EXISTS public.ledger_entries_open_credits;
+ALTER TABLE public.ledger_entries
+	DROP COLUMN IF EXISTS remaining,
+	DROP COLUMN IF EXISTS expires_at;
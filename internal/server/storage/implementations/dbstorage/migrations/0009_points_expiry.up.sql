@@ -0,0 +1,17 @@
+-- every credit keeps what is left of it after debits, credits with expires_at are written off when it passes
+ALTER TABLE public.ledger_entries
+	ADD COLUMN expires_at timestamptz,
+	ADD COLUMN remaining bigint not null default 0;
+
+-- credits made before expiry existed never expire, debits so far consumed them oldest first
+UPDATE public.ledger_entries c
+SET remaining = GREATEST(0, LEAST(c.amount, s.credited - s.debited))
+FROM (
+	SELECT entry_id,
+		sum(amount) FILTER (WHERE amount > 0) OVER (PARTITION BY user_id ORDER BY entry_id) AS credited,
+		coalesce(-sum(amount) FILTER (WHERE amount < 0) OVER (PARTITION BY user_id), 0) AS debited
+	FROM public.ledger_entries) s
+WHERE s.entry_id = c.entry_id AND c.amount > 0;
+
+CREATE INDEX ledger_entries_open_credits ON public.ledger_entries (user_id, expires_at, entry_id) WHERE remaining > 0;
+CREATE INDEX ledger_entries_expiring ON public.ledger_entries (expires_at, entry_id) WHERE remaining > 0 AND expires_at IS NOT NULL;
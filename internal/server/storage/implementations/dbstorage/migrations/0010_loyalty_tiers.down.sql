@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.tier_changes;
+ALTER TABLE public.users DROP COLUMN IF EXISTS tier;
@@ -0,0 +1,14 @@
+-- tier reached by the user, empty before the first one
+ALTER TABLE public.users ADD COLUMN tier varchar(40) not null default '';
+
+-- history of tier changes with points accrued within the tier period at the change
+CREATE TABLE public.tier_changes
+(	change_id 	bigserial 		primary key,
+	user_id 	varchar(40) 	not null references public.users (user_id),
+	from_tier 	varchar(40) 	not null default '',
+	to_tier 	varchar(40) 	not null default '',
+	accrued 	bigint 			not null default 0,
+	created_at 	timestamptz 	not null default now()
+);
+
+CREATE INDEX tier_changes_user ON public.tier_changes (user_id, change_id);
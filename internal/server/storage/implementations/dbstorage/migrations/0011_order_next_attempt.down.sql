@@ -0,0 +1,3 @@
+ALTER TABLE public.orders
+	DROP COLUMN IF EXISTS poll_attempts,
+	DROP COLUMN IF EXISTS next_attempt_at;
@@ -0,0 +1,4 @@
+-- orders the accrual system does not know yet are polled again at next_attempt_at, NULL is due at once
+ALTER TABLE public.orders
+	ADD COLUMN next_attempt_at timestamptz,
+	ADD COLUMN poll_attempts integer not null default 0;
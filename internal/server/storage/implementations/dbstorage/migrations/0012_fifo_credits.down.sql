@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.ledger_entries_open_credits;
+CREATE INDEX ledger_entries_open_credits ON public.ledger_entries (user_id, expires_at, entry_id) WHERE remaining > 0;
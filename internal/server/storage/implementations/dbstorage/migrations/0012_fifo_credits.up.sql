@@ -0,0 +1,3 @@
+-- debits consume open credits of the user in the order they were made
+DROP INDEX IF EXISTS public.ledger_entries_open_credits;
+CREATE INDEX ledger_entries_open_credits ON public.ledger_entries (user_id, entry_id) WHERE remaining > 0;
@@ -0,0 +1,916 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/alphaonly/gomartv2/internal/schema"
+	stor "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+)
+
+//In-memory storage with the same keys and conflict semantics as DBStorage tables,
+//used for tests and for running the service without database
+
+type orderKey struct {
+	order int64
+	user  string
+}
+
+type memOrder struct {
+	schema.Order
+	leaseOwner  string
+	leaseUntil  time.Time
+	nextAttempt time.Time
+}
+
+type ledgerKey struct {
+	order     int64
+	entryType schema.LedgerEntryType
+}
+
+type idempotencyKey struct {
+	user string
+	key  string
+}
+
+type idempotentWithdrawal struct {
+	entry schema.LedgerEntry
+	used  time.Time
+}
+
+type memDelivery struct {
+	schema.WebhookDelivery
+	leaseOwner string
+	leaseUntil time.Time
+}
+
+type memOutbox struct {
+	schema.OutboxMessage
+	leaseOwner string
+	leaseUntil time.Time
+}
+
+type MemStorage struct {
+	mu              sync.RWMutex
+	users           map[string]schema.User
+	orders          map[orderKey]*memOrder
+	ledger          []schema.LedgerEntry
+	ledgerOrders    map[ledgerKey]bool
+	remaining       map[int64]schema.Points //what is left of credits by entry id
+	idempotencyKeys map[idempotencyKey]idempotentWithdrawal
+	orderEvents     map[int64][]schema.OrderEvent
+	lastEventID     int64
+	webhooks        map[int64]schema.Webhook
+	lastWebhookID   int64
+	deliveries      []*memDelivery
+	lastDeliveryID  int64
+	tiers           map[string]string
+	tierChanges     []schema.TierChange
+	outbox          []*memOutbox
+	lastOutboxID    int64
+	revokedTokens   map[string]time.Time
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		users:           make(map[string]schema.User),
+		orders:          make(map[orderKey]*memOrder),
+		ledgerOrders:    make(map[ledgerKey]bool),
+		remaining:       make(map[int64]schema.Points),
+		tiers:           make(map[string]string),
+		idempotencyKeys: make(map[idempotencyKey]idempotentWithdrawal),
+		orderEvents:     make(map[int64][]schema.OrderEvent),
+		webhooks:        make(map[int64]schema.Webhook),
+		revokedTokens:   make(map[string]time.Time),
+	}
+}
+
+func (s *MemStorage) GetUser(ctx context.Context, name string) (u *schema.User, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: user %v", stor.ErrNotFound, name)
+	}
+	return &user, nil
+}
+
+func (s *MemStorage) SaveUser(ctx context.Context, u *schema.User) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//balance is a cache maintained by AddLedgerEntry only
+	user, ok := s.users[u.User]
+	if !ok {
+		user = schema.User{User: u.User}
+	}
+	user.Password = u.Password
+	s.users[u.User] = user
+	return nil
+}
+
+func (s *MemStorage) CreateUser(ctx context.Context, u *schema.User) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[u.User]; ok {
+		return fmt.Errorf("%w: %v", stor.ErrUserExists, u.User)
+	}
+	s.users[u.User] = schema.User{User: u.User, Password: u.Password}
+	return nil
+}
+
+func (s *MemStorage) UpdateUserPassword(ctx context.Context, name string, passwordHash string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return fmt.Errorf("%w: user %v", stor.ErrNotFound, name)
+	}
+	user.Password = passwordHash
+	s.users[name] = user
+	return nil
+}
+
+func (s *MemStorage) GetOrder(ctx context.Context, orderNumber int64) (o *schema.Order, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for key, order := range s.orders {
+		if key.order == orderNumber {
+			found := order.Order
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: order %v", stor.ErrNotFound, orderNumber)
+}
+
+func (s *MemStorage) GetOrders(ctx context.Context, numbers []int64) (ol schema.Orders, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[int64]bool, len(numbers))
+	for _, number := range numbers {
+		wanted[number] = true
+	}
+	ol = make(schema.Orders)
+	for key, order := range s.orders {
+		if wanted[key.order] {
+			ol[key.order] = order.Order
+		}
+	}
+	return ol, nil
+}
+
+func (s *MemStorage) SaveOrder(ctx context.Context, o schema.Order) (err error) {
+	taken, err := s.SaveOrders(ctx, []schema.Order{o})
+	if err != nil {
+		return err
+	}
+	if len(taken) > 0 {
+		return fmt.Errorf("%w: %v", stor.ErrOrderOwnedByOther, o.Order)
+	}
+	return nil
+}
+
+func (s *MemStorage) SaveOrders(ctx context.Context, ol []schema.Order) (taken []int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//messages are made first, so that a failure leaves nothing saved
+	messages := make([]schema.OutboxMessage, len(ol))
+	owners := make(map[int64]string, len(ol))
+	for i, o := range ol {
+		messages[i], err = schema.NewOutboxMessage(o.User, schema.TopicOrderUploaded, o.Order, o, o.Created)
+		if err != nil {
+			return nil, err
+		}
+		owners[o.Order] = ""
+	}
+	for key := range s.orders {
+		if _, ok := owners[key.order]; ok {
+			owners[key.order] = key.user
+		}
+	}
+	for i, o := range ol {
+		key := orderKey{order: o.Order, user: o.User}
+		if _, ok := s.orders[key]; ok {
+			//the same as ON CONFLICT DO NOTHING
+			continue
+		}
+		if owners[o.Order] != "" {
+			taken = append(taken, o.Order)
+			continue
+		}
+		owners[o.Order] = o.User
+		s.orders[key] = &memOrder{Order: o}
+		s.addOutboxMessage(messages[i])
+		s.addUploadEvent(o)
+	}
+	return taken, nil
+}
+
+// addUploadEvent starts the history of the saved order, s.mu must be locked
+func (s *MemStorage) addUploadEvent(o schema.Order) {
+	s.lastEventID++
+	s.orderEvents[o.Order] = append(s.orderEvents[o.Order], schema.OrderEvent{
+		ID:      s.lastEventID,
+		Order:   o.Order,
+		Type:    schema.OrderUploaded,
+		Status:  o.Status,
+		Created: o.Created,
+	})
+}
+
+func (s *MemStorage) GetOrdersList(ctx context.Context, userName string, q schema.ListQuery) (ol []schema.Order, more bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ol = make([]schema.Order, 0)
+	for key, order := range s.orders {
+		created := time.Time(order.Created)
+		if key.user == userName && q.InRange(created) && q.HasStatus(order.Status) && q.Follows(created, key.order) {
+			ol = append(ol, order.Order)
+		}
+	}
+	sort.Slice(ol, func(i, j int) bool {
+		return q.Less(time.Time(ol[i].Created), ol[i].Order, time.Time(ol[j].Created), ol[j].Order)
+	})
+	if q.Limit > 0 && len(ol) > q.Limit {
+		return ol[:q.Limit], true, nil
+	}
+	return ol, false, nil
+}
+
+func (s *MemStorage) GetNewOrdersList(ctx context.Context) (ol schema.Orders, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ol = make(schema.Orders)
+	for key, order := range s.orders {
+		if !order.Status.IsTerminal() {
+			ol[key.order] = order.Order
+		}
+	}
+	return ol, nil
+}
+
+func (s *MemStorage) ClaimOrders(ctx context.Context, owner string, lease time.Duration, limit int) (ol schema.Orders, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var free []*memOrder
+	for _, order := range s.orders {
+		if !order.Status.IsTerminal() && (order.leaseOwner == "" || order.leaseUntil.Before(now)) && !now.Before(order.nextAttempt) {
+			free = append(free, order)
+		}
+	}
+	sort.Slice(free, func(i, j int) bool {
+		return time.Time(free[i].Created).Before(time.Time(free[j].Created))
+	})
+
+	ol = make(schema.Orders)
+	for i := 0; i < len(free) && i < limit; i++ {
+		free[i].leaseOwner = owner
+		free[i].leaseUntil = now.Add(lease)
+		ol[free[i].Order.Order] = free[i].Order
+	}
+	return ol, nil
+}
+
+func (s *MemStorage) ReleaseOrder(ctx context.Context, orderNumber int64, owner string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, order := range s.orders {
+		if key.order == orderNumber && order.leaseOwner == owner {
+			order.leaseOwner = ""
+			order.leaseUntil = time.Time{}
+		}
+	}
+	return nil
+}
+
+func (s *MemStorage) PostponeOrder(ctx context.Context, orderNumber int64, owner string, attempts int, next time.Time) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, order := range s.orders {
+		if key.order == orderNumber && order.leaseOwner == owner {
+			order.PollAttempts = attempts
+			order.nextAttempt = next
+		}
+	}
+	return nil
+}
+
+func (s *MemStorage) UpdateOrderStatus(ctx context.Context, o schema.Order, tiers schema.Tiers) (credited schema.Points, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderKey{order: o.Order, user: o.User}]
+	if !ok {
+		return 0, fmt.Errorf("%w: order %v of user %v", stor.ErrNotFound, o.Order, o.User)
+	}
+	current := order.Status
+	if current == o.Status {
+		//repeated update, in particular an already credited order
+		return 0, nil
+	}
+	if !current.CanTransitionTo(o.Status) {
+		return 0, fmt.Errorf("%w: order %v from %v to %v", schema.ErrIllegalTransition, o.Order, current, o.Status)
+	}
+	now := time.Now()
+	if o.Status == schema.StatusProcessed {
+		//the tier the user has before the credit multiplies it
+		o.Accrual = tiers.Find(s.tiers[o.User]).Apply(o.Accrual)
+	}
+	updated := order.Order
+	updated.Status, updated.Accrual = o.Status, o.Accrual
+	m, err := schema.NewOutboxMessage(o.User, schema.TopicOrderStatus, o.Order, updated, schema.CreatedTime(now))
+	if err != nil {
+		return 0, err
+	}
+	if o.Status == schema.StatusProcessed && o.Accrual > 0 {
+		err = s.addLedgerEntry(schema.LedgerEntry{
+			User:    o.User,
+			Type:    schema.LedgerAccrual,
+			Amount:  o.Accrual,
+			Order:   o.Order,
+			Created: schema.CreatedTime(now),
+			Expires: o.AccrualExpires,
+		})
+		if err != nil {
+			return 0, err
+		}
+		credited = o.Accrual
+	}
+	order.Status = o.Status
+	order.Accrual = o.Accrual
+	s.addOutboxMessage(m)
+	if o.Status == schema.StatusProcessed && len(tiers) > 0 {
+		accrued := s.accrued(o.User, now.AddDate(0, -schema.TierPeriodMonths, 0))
+		s.setUserTier(schema.TierChange{User: o.User, To: tiers.For(accrued).Name, Accrued: accrued, Created: schema.CreatedTime(now)})
+	}
+	return credited, nil
+}
+
+func (s *MemStorage) AddOrderEvent(ctx context.Context, e schema.OrderEvent) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.orderExists(e.Order) {
+		return fmt.Errorf("%w: order %v", stor.ErrNotFound, e.Order)
+	}
+	events := s.orderEvents[e.Order]
+	if len(events) > 0 && e.Repeats(events[len(events)-1]) {
+		return nil
+	}
+	s.lastEventID++
+	e.ID = s.lastEventID
+	s.orderEvents[e.Order] = append(events, e)
+	return nil
+}
+
+func (s *MemStorage) GetOrderEvents(ctx context.Context, orderNumber int64) (el []schema.OrderEvent, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.orderExists(orderNumber) {
+		return nil, fmt.Errorf("%w: order %v", stor.ErrNotFound, orderNumber)
+	}
+	el = append([]schema.OrderEvent(nil), s.orderEvents[orderNumber]...)
+	sort.SliceStable(el, func(i, j int) bool {
+		return time.Time(el[i].Created).Before(time.Time(el[j].Created))
+	})
+	return el, nil
+}
+
+func (s *MemStorage) orderExists(orderNumber int64) bool {
+	for key := range s.orders {
+		if key.order == orderNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemStorage) GetWithdrawalsList(ctx context.Context, userName string, q schema.ListQuery) (wl *schema.Withdrawals, more bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wl = new(schema.Withdrawals)
+	*wl = make(schema.Withdrawals, 0)
+	for _, e := range s.ledger {
+		created := time.Time(e.Created)
+		if e.User != userName || e.Type != schema.LedgerWithdrawal || !q.InRange(created) || !q.Follows(created, e.ID) {
+			continue
+		}
+		//withdrawal entries are stored as negative amounts
+		*wl = append(*wl, schema.Withdrawal{
+			ID:         e.ID,
+			Order:      e.Order,
+			User:       e.User,
+			Processed:  e.Created,
+			Withdrawal: -e.Amount,
+		})
+	}
+	sort.Slice(*wl, func(i, j int) bool {
+		return q.Less(time.Time((*wl)[i].Processed), (*wl)[i].ID, time.Time((*wl)[j].Processed), (*wl)[j].ID)
+	})
+	if q.Limit > 0 && len(*wl) > q.Limit {
+		*wl = (*wl)[:q.Limit]
+		return wl, true, nil
+	}
+	return wl, false, nil
+}
+
+func (s *MemStorage) AddLedgerEntry(ctx context.Context, e schema.LedgerEntry) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addLedgerEntry(e)
+}
+
+func (s *MemStorage) Withdraw(ctx context.Context, e schema.LedgerEntry, key string) (replayed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key == "" {
+		return false, s.addLedgerEntry(e)
+	}
+	now := time.Now()
+	for k, done := range s.idempotencyKeys {
+		if now.Sub(done.used) > stor.IdempotencyKeyTTL {
+			delete(s.idempotencyKeys, k)
+		}
+	}
+	if _, ok := s.users[e.User]; !ok {
+		return false, fmt.Errorf("%w: user %v", stor.ErrNotFound, e.User)
+	}
+	k := idempotencyKey{user: e.User, key: key}
+	if done, ok := s.idempotencyKeys[k]; ok {
+		if done.entry.Order != e.Order || done.entry.Amount != e.Amount {
+			return false, fmt.Errorf("%w: %v", domain.ErrIdempotencyKeyReused, key)
+		}
+		return true, nil
+	}
+	if err = s.addLedgerEntry(e); err != nil {
+		return false, err
+	}
+	s.idempotencyKeys[k] = idempotentWithdrawal{entry: e, used: now}
+	return false, nil
+}
+
+// addLedgerEntry appends the entry and updates cached balance, s.mu must be locked
+func (s *MemStorage) addLedgerEntry(e schema.LedgerEntry) (err error) {
+	user, ok := s.users[e.User]
+	if !ok {
+		return fmt.Errorf("%w: user %v", stor.ErrNotFound, e.User)
+	}
+	key := ledgerKey{order: e.Order, entryType: e.Type}
+	if e.Order != 0 && s.ledgerOrders[key] {
+		return fmt.Errorf("%w: %v of order %v", stor.ErrLedgerEntryExists, e.Type, e.Order)
+	}
+	balance := schema.Balance{Current: user.Accrual, Withdrawn: user.Withdrawal}
+	balance.Apply(e)
+	if e.Amount < 0 && balance.Current < 0 {
+		return stor.ErrInsufficientFunds
+	}
+
+	e.ID = int64(len(s.ledger) + 1)
+	m, err := schema.NewOutboxMessage(e.User, schema.TopicLedgerEntry, e.Order, e, e.Created)
+	if err != nil {
+		return err
+	}
+	s.ledger = append(s.ledger, e)
+	//the expired credit is written off by the caller
+	switch {
+	case e.Amount > 0:
+		s.remaining[e.ID] = e.Amount
+	case e.Type != schema.LedgerExpiration:
+		s.consumeCredits(e.User, -e.Amount)
+	}
+	s.addOutboxMessage(m)
+	if e.Order != 0 {
+		s.ledgerOrders[key] = true
+	}
+	user.Accrual, user.Withdrawal = balance.Current, balance.Withdrawn
+	s.users[e.User] = user
+	return nil
+}
+
+// consumeCredits takes amount from what is left of the user's credits oldest first, s.mu must be locked
+func (s *MemStorage) consumeCredits(userName string, amount schema.Points) {
+	var credits []schema.LedgerEntry
+	for id := range s.remaining {
+		if e := s.ledger[id-1]; e.User == userName {
+			credits = append(credits, e)
+		}
+	}
+	sort.Slice(credits, func(i, j int) bool {
+		return credits[i].ID < credits[j].ID
+	})
+	for _, e := range credits {
+		if amount == 0 {
+			return
+		}
+		taken := s.remaining[e.ID]
+		if taken > amount {
+			taken = amount
+		}
+		amount -= taken
+		s.remaining[e.ID] -= taken
+		if s.remaining[e.ID] == 0 {
+			delete(s.remaining, e.ID)
+		}
+	}
+}
+
+// expiresBefore orders credits soonest expiring first, credits that never expire are the last
+func expiresBefore(a, b schema.LedgerEntry) bool {
+	expiresA, expiresB := time.Time(a.Expires), time.Time(b.Expires)
+	switch {
+	case expiresA.Equal(expiresB):
+		return a.ID < b.ID
+	case expiresA.IsZero():
+		return false
+	case expiresB.IsZero():
+		return true
+	}
+	return expiresA.Before(expiresB)
+}
+
+func (s *MemStorage) GetExpiringPoints(ctx context.Context, userName string, before time.Time) (el []schema.ExpiringPoints, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var credits []schema.LedgerEntry
+	for id := range s.remaining {
+		e := s.ledger[id-1]
+		if e.User == userName && !time.Time(e.Expires).IsZero() && time.Time(e.Expires).Before(before) {
+			credits = append(credits, e)
+		}
+	}
+	sort.Slice(credits, func(i, j int) bool {
+		return expiresBefore(credits[i], credits[j])
+	})
+	el = make([]schema.ExpiringPoints, 0, len(credits))
+	for _, e := range credits {
+		el = append(el, schema.ExpiringPoints{Order: e.Order, Amount: s.remaining[e.ID], Expires: e.Expires})
+	}
+	return el, nil
+}
+
+func (s *MemStorage) ExpirePoints(ctx context.Context, due time.Time, limit int) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []schema.LedgerEntry
+	for id := range s.remaining {
+		e := s.ledger[id-1]
+		if !time.Time(e.Expires).IsZero() && !time.Time(e.Expires).After(due) {
+			expired = append(expired, e)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expiresBefore(expired[i], expired[j])
+	})
+	now := schema.CreatedTime(time.Now())
+	for i := 0; i < len(expired) && i < limit; i++ {
+		err = s.addLedgerEntry(schema.LedgerEntry{
+			User:    expired[i].User,
+			Type:    schema.LedgerExpiration,
+			Amount:  -s.remaining[expired[i].ID],
+			Order:   expired[i].Order,
+			Created: now,
+		})
+		if err != nil {
+			return n, err
+		}
+		delete(s.remaining, expired[i].ID)
+		n++
+	}
+	return n, nil
+}
+
+func (s *MemStorage) GetBalance(ctx context.Context, userName string) (b *schema.Balance, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b = new(schema.Balance)
+	for _, e := range s.ledger {
+		if e.User == userName {
+			b.Apply(e)
+		}
+	}
+	return b, nil
+}
+
+func (s *MemStorage) GetAccrued(ctx context.Context, userName string, since time.Time) (p schema.Points, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.accrued(userName, since), nil
+}
+
+// accrued sums the user's accrual credits made since the time, s.mu has to be locked
+func (s *MemStorage) accrued(userName string, since time.Time) (p schema.Points) {
+	for _, e := range s.ledger {
+		if e.User == userName && e.Type == schema.LedgerAccrual && !time.Time(e.Created).Before(since) {
+			p += e.Amount
+		}
+	}
+	return p
+}
+
+func (s *MemStorage) GetUserTier(ctx context.Context, userName string) (tier string, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[userName]; !ok {
+		return "", fmt.Errorf("%w: user %v", stor.ErrNotFound, userName)
+	}
+	return s.tiers[userName], nil
+}
+
+func (s *MemStorage) SetUserTier(ctx context.Context, c schema.TierChange) (changed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[c.User]; !ok {
+		return false, fmt.Errorf("%w: user %v", stor.ErrNotFound, c.User)
+	}
+	return s.setUserTier(c), nil
+}
+
+// setUserTier moves the existing user to c.To recording the change, s.mu has to be locked
+func (s *MemStorage) setUserTier(c schema.TierChange) (changed bool) {
+	c.From = s.tiers[c.User]
+	if c.From == c.To {
+		return false
+	}
+	c.ID = int64(len(s.tierChanges) + 1)
+	s.tierChanges = append(s.tierChanges, c)
+	s.tiers[c.User] = c.To
+	return true
+}
+
+func (s *MemStorage) GetTierChanges(ctx context.Context, userName string) (cl []schema.TierChange, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cl = make([]schema.TierChange, 0)
+	for _, c := range s.tierChanges {
+		if c.User == userName {
+			cl = append(cl, c)
+		}
+	}
+	return cl, nil
+}
+
+func (s *MemStorage) SaveWebhook(ctx context.Context, w schema.Webhook) (id int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[w.User]; !ok {
+		return 0, fmt.Errorf("%w: user %v", stor.ErrNotFound, w.User)
+	}
+	for _, existing := range s.webhooks {
+		if existing.User == w.User && existing.URL == w.URL {
+			return 0, fmt.Errorf("%w: %v", stor.ErrWebhookExists, w.URL)
+		}
+	}
+	s.lastWebhookID++
+	w.ID = s.lastWebhookID
+	s.webhooks[w.ID] = w
+	return w.ID, nil
+}
+
+func (s *MemStorage) GetWebhooks(ctx context.Context, userName string) (wl []schema.Webhook, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, w := range s.webhooks {
+		if w.User == userName {
+			wl = append(wl, w)
+		}
+	}
+	sort.Slice(wl, func(i, j int) bool { return wl[i].ID < wl[j].ID })
+	return wl, nil
+}
+
+func (s *MemStorage) DeleteWebhook(ctx context.Context, userName string, id int64) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.webhooks[id]
+	if !ok || w.User != userName {
+		return fmt.Errorf("%w: webhook %v of user %v", stor.ErrNotFound, id, userName)
+	}
+	delete(s.webhooks, id)
+	//the same as ON DELETE CASCADE
+	deliveries := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if d.Webhook != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.deliveries = deliveries
+	return nil
+}
+
+func (s *MemStorage) AddWebhookDeliveries(ctx context.Context, d schema.WebhookDelivery) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, 0)
+	for id, w := range s.webhooks {
+		if w.User == d.User {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		delivery := d
+		s.lastDeliveryID++
+		delivery.ID = s.lastDeliveryID
+		delivery.Webhook = id
+		delivery.URL = s.webhooks[id].URL
+		delivery.Status = schema.DeliveryPending
+		if time.Time(delivery.NextAttempt).IsZero() {
+			delivery.NextAttempt = delivery.Created
+		}
+		s.deliveries = append(s.deliveries, &memDelivery{WebhookDelivery: delivery})
+	}
+	return len(ids), nil
+}
+
+func (s *MemStorage) ClaimWebhookDeliveries(ctx context.Context, owner string, lease time.Duration, limit int) (dl []schema.WebhookDelivery, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var due []*memDelivery
+	for _, d := range s.deliveries {
+		if d.Status == schema.DeliveryPending && !time.Time(d.NextAttempt).After(now) && (d.leaseOwner == "" || d.leaseUntil.Before(now)) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		ti, tj := time.Time(due[i].NextAttempt), time.Time(due[j].NextAttempt)
+		return ti.Before(tj) || (ti.Equal(tj) && due[i].ID < due[j].ID)
+	})
+	for i := 0; i < len(due) && i < limit; i++ {
+		due[i].leaseOwner = owner
+		due[i].leaseUntil = now.Add(lease)
+		dl = append(dl, due[i].WebhookDelivery)
+	}
+	return dl, nil
+}
+
+func (s *MemStorage) UpdateWebhookDelivery(ctx context.Context, d schema.WebhookDelivery, owner string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delivery := range s.deliveries {
+		if delivery.ID != d.ID {
+			continue
+		}
+		if delivery.leaseOwner != owner {
+			break
+		}
+		delivery.Status = d.Status
+		delivery.Attempts = d.Attempts
+		delivery.ResponseStatus = d.ResponseStatus
+		delivery.LastError = d.LastError
+		delivery.NextAttempt = d.NextAttempt
+		delivery.leaseOwner = ""
+		delivery.leaseUntil = time.Time{}
+		return nil
+	}
+	return fmt.Errorf("%w: delivery %v leased to %v", stor.ErrNotFound, d.ID, owner)
+}
+
+func (s *MemStorage) GetWebhookDeliveries(ctx context.Context, userName string, q schema.ListQuery) (dl []schema.WebhookDelivery, more bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dl = make([]schema.WebhookDelivery, 0)
+	for _, d := range s.deliveries {
+		created := time.Time(d.Created)
+		if d.User == userName && q.InRange(created) && q.Follows(created, d.ID) {
+			dl = append(dl, d.WebhookDelivery)
+		}
+	}
+	sort.Slice(dl, func(i, j int) bool {
+		return q.Less(time.Time(dl[i].Created), dl[i].ID, time.Time(dl[j].Created), dl[j].ID)
+	})
+	if q.Limit > 0 && len(dl) > q.Limit {
+		return dl[:q.Limit], true, nil
+	}
+	return dl, false, nil
+}
+
+// addOutboxMessage appends m to the outbox, s.mu must be locked
+func (s *MemStorage) addOutboxMessage(m schema.OutboxMessage) {
+	s.lastOutboxID++
+	m.ID = s.lastOutboxID
+	s.outbox = append(s.outbox, &memOutbox{OutboxMessage: m})
+}
+
+func (s *MemStorage) ClaimOutbox(ctx context.Context, owner string, lease time.Duration, limit int) (ml []schema.OutboxMessage, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	//a user with a message that is leased or waits for retry has the rest of the messages blocked behind it
+	blocked := make(map[string]bool)
+	for _, m := range s.outbox {
+		if len(ml) == limit {
+			break
+		}
+		if blocked[m.User] {
+			continue
+		}
+		if m.leaseUntil.After(now) || time.Time(m.NextAttempt).After(now) {
+			blocked[m.User] = true
+			continue
+		}
+		m.leaseOwner = owner
+		m.leaseUntil = now.Add(lease)
+		ml = append(ml, m.OutboxMessage)
+	}
+	return ml, nil
+}
+
+func (s *MemStorage) AckOutboxMessage(ctx context.Context, id int64, owner string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range s.outbox {
+		if m.ID == id && m.leaseOwner == owner {
+			s.outbox = append(s.outbox[:i], s.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: outbox message %v leased to %v", stor.ErrNotFound, id, owner)
+}
+
+func (s *MemStorage) RetryOutboxMessage(ctx context.Context, m schema.OutboxMessage, owner string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var failed *memOutbox
+	for _, message := range s.outbox {
+		if message.ID == m.ID && message.leaseOwner == owner {
+			failed = message
+			break
+		}
+	}
+	if failed == nil {
+		return fmt.Errorf("%w: outbox message %v leased to %v", stor.ErrNotFound, m.ID, owner)
+	}
+	failed.Attempts = m.Attempts
+	failed.LastError = m.LastError
+	failed.NextAttempt = m.NextAttempt
+	for _, message := range s.outbox {
+		if message.User == failed.User && message.ID >= failed.ID && message.leaseOwner == owner {
+			message.leaseOwner = ""
+			message.leaseUntil = time.Time{}
+		}
+	}
+	return nil
+}
+
+func (s *MemStorage) RevokeToken(ctx context.Context, tokenID string, expires time.Time) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revokedTokens[tokenID]; !ok {
+		s.revokedTokens[tokenID] = expires
+	}
+	//revoked tokens are only needed until they expire by themselves
+	now := time.Now()
+	for id, expiresAt := range s.revokedTokens {
+		if expiresAt.Before(now) {
+			delete(s.revokedTokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemStorage) IsTokenRevoked(ctx context.Context, tokenID string) (revoked bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, revoked = s.revokedTokens[tokenID]
+	return revoked, nil
+}
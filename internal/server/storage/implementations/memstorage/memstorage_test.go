@@ -0,0 +1,12 @@
+package storage
+
+import (
+	"testing"
+
+	stor "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+	"github.com/alphaonly/gomartv2/internal/server/storage/storagetest"
+)
+
+func TestMemStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) stor.Storage { return NewMemStorage() })
+}
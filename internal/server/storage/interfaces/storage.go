@@ -2,18 +2,128 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/alphaonly/gomartv2/internal/domain"
 	"github.com/alphaonly/gomartv2/internal/schema"
 )
 
+// ErrNotFound is returned when the requested user or order does not exist,
+// any other error means the storage failed and nothing can be said about existence
+var ErrNotFound = errors.New("not found")
+
+// ErrLedgerEntryExists is returned for the second ledger entry of the same type for an order
+var ErrLedgerEntryExists = errors.New("ledger entry for the order already exists")
+
+// IdempotencyKeyTTL is how long a withdrawal is remembered by its idempotency key
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// ErrInsufficientFunds is the domain error, so that it passes through handlers unchanged
+var ErrInsufficientFunds = domain.ErrInsufficientFunds
+
+// ErrUserExists is returned by CreateUser for an occupied login
+var ErrUserExists = domain.ErrUserExists
+
+// ErrOrderOwnedByOther is returned by SaveOrder for a number uploaded by another user
+var ErrOrderOwnedByOther = domain.ErrOrderOwnedByOther
+
+// ErrWebhookExists is returned for the second webhook of the user with the same url
+var ErrWebhookExists = domain.ErrWebhookExists
+
 type Storage interface {
 	GetUser(ctx context.Context, name string) (u *schema.User, err error)
 	SaveUser(ctx context.Context, u *schema.User) (err error)
+	// CreateUser saves a new user, ErrUserExists is returned if the login is occupied, the existing user is kept
+	CreateUser(ctx context.Context, u *schema.User) (err error)
+	UpdateUserPassword(ctx context.Context, name string, passwordHash string) (err error)
 
 	GetOrder(ctx context.Context, orderNumber int64) (o *schema.Order, err error)
+	// GetOrders returns the uploaded orders among numbers, numbers not uploaded yet are left out
+	GetOrders(ctx context.Context, numbers []int64) (ol schema.Orders, err error)
+	// SaveOrder saves the order with its upload event in one transaction,
+	// an order already uploaded by the same user is skipped, a number uploaded by another user fails with ErrOrderOwnedByOther
 	SaveOrder(ctx context.Context, o schema.Order) (err error)
-	GetOrdersList(ctx context.Context, userName string) (ol schema.Orders, err error)
+	// SaveOrders saves the batch of orders with their upload events in one transaction,
+	// orders already uploaded by the same user are skipped as SaveOrder does.
+	// Taken are the numbers uploaded by another user, they are not saved.
+	SaveOrders(ctx context.Context, ol []schema.Order) (taken []int64, err error)
+	// GetOrdersList returns the page of user's orders selected by q ordered by upload time,
+	// more tells there are orders after the page
+	GetOrdersList(ctx context.Context, userName string, q schema.ListQuery) (ol []schema.Order, more bool, err error)
+	// GetNewOrdersList returns orders in non-terminal statuses
 	GetNewOrdersList(ctx context.Context) (ol schema.Orders, err error)
-	SaveWithdrawal(ctx context.Context, w schema.Withdrawal) (err error)
-	GetWithdrawalsList(ctx context.Context, userName string) (wl *schema.Withdrawals, err error)
+	// ClaimOrders leases up to limit orders in non-terminal statuses due by now to owner,
+	// orders leased by another owner are skipped until their lease expires
+	ClaimOrders(ctx context.Context, owner string, lease time.Duration, limit int) (ol schema.Orders, err error)
+	ReleaseOrder(ctx context.Context, orderNumber int64, owner string) (err error)
+	// PostponeOrder saves attempts of the order leased to owner and makes it due at next, zero next is due at once
+	PostponeOrder(ctx context.Context, orderNumber int64, owner string, attempts int, next time.Time) (err error)
+	// UpdateOrderStatus moves the order to o.Status refusing illegal transitions with schema.ErrIllegalTransition,
+	// moving to PROCESSED credits o.Accrual multiplied by the user's tier of tiers and moves the user to the tier
+	// of points accrued within schema.TierPeriodMonths in the same transaction. Credited is the amount credited,
+	// repeated updates are no-op crediting nothing.
+	UpdateOrderStatus(ctx context.Context, o schema.Order, tiers schema.Tiers) (credited schema.Points, err error)
+	// AddOrderEvent appends e to the order history, an event repeating the last one of the order is skipped
+	// so that polling the same accrual status again does not grow the history. Unknown order fails with ErrNotFound.
+	AddOrderEvent(ctx context.Context, e schema.OrderEvent) (err error)
+	// GetOrderEvents returns the order history oldest first
+	GetOrderEvents(ctx context.Context, orderNumber int64) (el []schema.OrderEvent, err error)
+	// GetWithdrawalsList returns the page of user's withdrawals selected by q ordered by processing time,
+	// q.Statuses is not applicable to withdrawals
+	GetWithdrawalsList(ctx context.Context, userName string, q schema.ListQuery) (wl *schema.Withdrawals, more bool, err error)
+
+	// AddLedgerEntry appends the entry and updates cached balance in one transaction,
+	// a debit that would make the balance negative fails with ErrInsufficientFunds.
+	// Every credit keeps what is left of it: debits consume credits first in first out whenever they expire,
+	// an expiration entry writes off the rest of its own credit.
+	AddLedgerEntry(ctx context.Context, e schema.LedgerEntry) (err error)
+	// Withdraw appends withdrawal entry e like AddLedgerEntry. With non-empty idempotencyKey it is done once
+	// per user and key: a retry with the same key and entry is replayed without debiting again,
+	// the key used for another entry fails with domain.ErrIdempotencyKeyReused. Failed withdrawals are not remembered.
+	Withdraw(ctx context.Context, e schema.LedgerEntry, idempotencyKey string) (replayed bool, err error)
+	GetBalance(ctx context.Context, userName string) (b *schema.Balance, err error)
+	// GetExpiringPoints returns what is left of the user's credits expiring before the time, soonest first
+	GetExpiringPoints(ctx context.Context, userName string, before time.Time) (el []schema.ExpiringPoints, err error)
+	// ExpirePoints writes off what is left of up to limit credits expired by due with expiration entries
+	// and returns the number of credits written off
+	ExpirePoints(ctx context.Context, due time.Time, limit int) (n int, err error)
+
+	// GetAccrued sums the user's accrual credits made since the time
+	GetAccrued(ctx context.Context, userName string, since time.Time) (p schema.Points, err error)
+	// GetUserTier returns the name of the user's tier, empty before the user reaches the first one
+	GetUserTier(ctx context.Context, userName string) (tier string, err error)
+	// SetUserTier moves the user to c.To and records c with c.From set to the previous tier,
+	// changed is false and nothing is recorded if the user is in c.To already
+	SetUserTier(ctx context.Context, c schema.TierChange) (changed bool, err error)
+	// GetTierChanges returns the user's tier history oldest first
+	GetTierChanges(ctx context.Context, userName string) (cl []schema.TierChange, err error)
+
+	// SaveWebhook saves the user's webhook and returns its id, the same url twice fails with ErrWebhookExists
+	SaveWebhook(ctx context.Context, w schema.Webhook) (id int64, err error)
+	GetWebhooks(ctx context.Context, userName string) (wl []schema.Webhook, err error)
+	// DeleteWebhook removes the user's webhook with its deliveries, webhook of another user is ErrNotFound
+	DeleteWebhook(ctx context.Context, userName string, id int64) (err error)
+	// AddWebhookDeliveries queues delivery d to every webhook of d.User and returns the number of deliveries
+	AddWebhookDeliveries(ctx context.Context, d schema.WebhookDelivery) (n int, err error)
+	// ClaimWebhookDeliveries leases up to limit pending deliveries due by now to owner like ClaimOrders does
+	ClaimWebhookDeliveries(ctx context.Context, owner string, lease time.Duration, limit int) (dl []schema.WebhookDelivery, err error)
+	// UpdateWebhookDelivery saves the outcome of the delivery attempt and releases the lease,
+	// a delivery not leased to owner is ErrNotFound
+	UpdateWebhookDelivery(ctx context.Context, d schema.WebhookDelivery, owner string) (err error)
+	// GetWebhookDeliveries returns the page of the user's delivery log ordered by creation time
+	GetWebhookDeliveries(ctx context.Context, userName string, q schema.ListQuery) (dl []schema.WebhookDelivery, more bool, err error)
+
+	// ClaimOutbox leases up to limit outbox messages due by now to owner oldest first. A message is claimed only
+	// together with or after every earlier message of its user, so that the user's messages are delivered in order.
+	// SaveOrder, SaveOrders, UpdateOrderStatus, AddLedgerEntry and Withdraw add messages in their transactions.
+	ClaimOutbox(ctx context.Context, owner string, lease time.Duration, limit int) (ml []schema.OutboxMessage, err error)
+	// AckOutboxMessage removes the delivered message, a message not leased to owner is ErrNotFound
+	AckOutboxMessage(ctx context.Context, id int64, owner string) (err error)
+	// RetryOutboxMessage saves the failed attempt of m and releases the leases of owner on m and on the later
+	// messages of its user, they are claimed again after m.NextAttempt
+	RetryOutboxMessage(ctx context.Context, m schema.OutboxMessage, owner string) (err error)
+
+	RevokeToken(ctx context.Context, tokenID string, expires time.Time) (err error)
+	IsTokenRevoked(ctx context.Context, tokenID string) (revoked bool, err error)
 }
@@ -0,0 +1,1198 @@
+// Package storagetest is the contract every storage.Storage implementation must satisfy.
+// An implementation plugs in with its own test:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) stor.Storage { return NewMemStorage() })
+//	}
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/alphaonly/gomartv2/internal/schema"
+	stor "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory returns an empty storage, every subtest gets its own one
+type Factory func(t *testing.T) stor.Storage
+
+// Run checks all methods of storage.Storage against the storage made by factory
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s stor.Storage)
+	}{
+		{name: "users", test: testUsers},
+		{name: "orders", test: testOrders},
+		{name: "order batches", test: testOrderBatches},
+		{name: "new orders", test: testNewOrders},
+		{name: "claim orders", test: testClaimOrders},
+		{name: "postponed orders", test: testPostponedOrders},
+		{name: "order status", test: testOrderStatus},
+		{name: "order events", test: testOrderEvents},
+		{name: "ledger", test: testLedger},
+		{name: "orders pages", test: testOrdersPages},
+		{name: "withdrawals", test: testWithdrawals},
+		{name: "withdrawals pages", test: testWithdrawalsPages},
+		{name: "idempotent withdrawals", test: testIdempotentWithdrawals},
+		{name: "webhooks", test: testWebhooks},
+		{name: "webhook deliveries", test: testWebhookDeliveries},
+		{name: "points expiry", test: testPointsExpiry},
+		{name: "tiers", test: testTiers},
+		{name: "order status tiers", test: testOrderStatusTiers},
+		{name: "outbox", test: testOutbox},
+		{name: "revoked tokens", test: testRevokedTokens},
+		{name: "concurrent withdrawals", test: testConcurrentWithdrawals},
+		{name: "concurrent credits", test: testConcurrentCredits},
+		{name: "concurrent claims", test: testConcurrentClaims},
+		{name: "concurrent uploads", test: testConcurrentUploads},
+		{name: "concurrent retries", test: testConcurrentRetries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+// created times are whole seconds as not every storage keeps fractions
+var baseTime = time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) schema.CreatedTime {
+	return schema.CreatedTime(baseTime.Add(time.Duration(seconds) * time.Second))
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func saveUser(t *testing.T, s stor.Storage, name string) {
+	t.Helper()
+	must(t, s.SaveUser(context.Background(), &schema.User{User: name, Password: "hash-" + name}))
+}
+
+func saveOrder(t *testing.T, s stor.Storage, number int64, user string, created schema.CreatedTime) {
+	t.Helper()
+	must(t, s.SaveOrder(context.Background(), schema.Order{Order: number, User: user, Status: schema.StatusNew, Created: created}))
+}
+
+func credit(t *testing.T, s stor.Storage, user string, amount schema.Points) {
+	t.Helper()
+	must(t, s.AddLedgerEntry(context.Background(), schema.LedgerEntry{
+		User: user, Type: schema.LedgerAdjustment, Amount: amount, Created: at(0),
+	}))
+}
+
+// updateStatus updates the order of users without tiers
+func updateStatus(s stor.Storage, o schema.Order) error {
+	_, err := s.UpdateOrderStatus(context.Background(), o, nil)
+	return err
+}
+
+func assertNotFound(t *testing.T, err error) {
+	t.Helper()
+	assert.True(t, errors.Is(err, stor.ErrNotFound), "want ErrNotFound, got %v", err)
+}
+
+func assertSameOrder(t *testing.T, want schema.Order, got schema.Order) {
+	t.Helper()
+	assert.Equal(t, want.Order, got.Order)
+	assert.Equal(t, want.User, got.User)
+	assert.Equal(t, want.Status, got.Status)
+	assert.Equal(t, want.Accrual, got.Accrual)
+	assert.True(t, time.Time(want.Created).Equal(time.Time(got.Created)), "created %v, want %v", time.Time(got.Created), time.Time(want.Created))
+}
+
+func testUsers(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+
+	_, err := s.GetUser(ctx, "nobody")
+	assertNotFound(t, err)
+
+	must(t, s.SaveUser(ctx, &schema.User{User: "alice", Password: "hash1"}))
+	u, err := s.GetUser(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.User{User: "alice", Password: "hash1"}, *u)
+
+	//saving again changes the password only, balance is kept by the ledger
+	credit(t, s, "alice", 500)
+	must(t, s.SaveUser(ctx, &schema.User{User: "alice", Password: "hash2", Accrual: 1}))
+	u, err = s.GetUser(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, "hash2", u.Password)
+	assert.Equal(t, schema.Points(500), u.Accrual)
+
+	must(t, s.UpdateUserPassword(ctx, "alice", "hash3"))
+	u, err = s.GetUser(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, "hash3", u.Password)
+
+	assertNotFound(t, s.UpdateUserPassword(ctx, "nobody", "hash"))
+
+	//creating never overwrites the user
+	must(t, s.CreateUser(ctx, &schema.User{User: "bob", Password: "hash1"}))
+	err = s.CreateUser(ctx, &schema.User{User: "bob", Password: "hash2"})
+	assert.True(t, errors.Is(err, stor.ErrUserExists), "want ErrUserExists, got %v", err)
+	u, err = s.GetUser(ctx, "bob")
+	must(t, err)
+	assert.Equal(t, "hash1", u.Password)
+}
+
+func testOrders(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+
+	_, err := s.GetOrder(ctx, 12345678903)
+	assertNotFound(t, err)
+
+	//order numbers do not fit into int32
+	want := schema.Order{Order: 12345678903, User: "alice", Status: schema.StatusNew, Created: at(1)}
+	must(t, s.SaveOrder(ctx, want))
+	got, err := s.GetOrder(ctx, want.Order)
+	must(t, err)
+	assertSameOrder(t, want, *got)
+
+	//repeated upload of the same order keeps the first one
+	must(t, s.SaveOrder(ctx, schema.Order{Order: want.Order, User: "alice", Status: schema.StatusNew, Created: at(5)}))
+	got, err = s.GetOrder(ctx, want.Order)
+	must(t, err)
+	assertSameOrder(t, want, *got)
+
+	saveOrder(t, s, 79927398713, "alice", at(2))
+	saveOrder(t, s, 4561261212345467, "bob", at(3))
+
+	ol, more, err := s.GetOrdersList(ctx, "alice", schema.ListQuery{})
+	must(t, err)
+	assert.False(t, more)
+	if assert.Len(t, ol, 2) {
+		assertSameOrder(t, want, ol[0])
+		assertSameOrder(t, schema.Order{Order: 79927398713, User: "alice", Status: schema.StatusNew, Created: at(2)}, ol[1])
+	}
+
+	ol, _, err = s.GetOrdersList(ctx, "nobody", schema.ListQuery{})
+	must(t, err)
+	assert.Empty(t, ol)
+}
+
+func testOrderBatches(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveOrder(t, s, 1, "alice", at(1))
+
+	batch := []schema.Order{
+		{Order: 1, User: "alice", Status: schema.StatusNew, Created: at(2)},
+		{Order: 2, User: "alice", Status: schema.StatusNew, Created: at(2)},
+		{Order: 3, User: "alice", Status: schema.StatusNew, Created: at(2)},
+	}
+	taken, err := s.SaveOrders(ctx, batch)
+	must(t, err)
+	assert.Empty(t, taken)
+	taken, err = s.SaveOrders(ctx, nil)
+	must(t, err)
+	assert.Empty(t, taken)
+
+	ol, _, err := s.GetOrdersList(ctx, "alice", schema.ListQuery{})
+	must(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, orderNumbers(ol))
+
+	//numbers of another user are not saved
+	saveUser(t, s, "bob")
+	taken, err = s.SaveOrders(ctx, []schema.Order{
+		{Order: 2, User: "bob", Status: schema.StatusNew, Created: at(3)},
+		{Order: 4, User: "bob", Status: schema.StatusNew, Created: at(3)},
+	})
+	must(t, err)
+	assert.Equal(t, []int64{2}, taken)
+	err = s.SaveOrder(ctx, schema.Order{Order: 3, User: "bob", Status: schema.StatusNew, Created: at(3)})
+	assert.True(t, errors.Is(err, stor.ErrOrderOwnedByOther), "got %v", err)
+	bl, _, err := s.GetOrdersList(ctx, "bob", schema.ListQuery{})
+	must(t, err)
+	assert.Equal(t, []int64{4}, orderNumbers(bl))
+
+	found, err := s.GetOrders(ctx, []int64{1, 4, 5})
+	must(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, "alice", found[1].User)
+		assert.Equal(t, "bob", found[4].User)
+	}
+	//the order uploaded before keeps its time and gets no second upload event
+	assertSameOrder(t, schema.Order{Order: 1, User: "alice", Status: schema.StatusNew, Created: at(1)}, ol[0])
+	el, err := s.GetOrderEvents(ctx, 1)
+	must(t, err)
+	if assert.Len(t, el, 1) {
+		assert.True(t, time.Time(at(1)).Equal(time.Time(el[0].Created)))
+	}
+
+	for _, number := range []int64{2, 3} {
+		el, err := s.GetOrderEvents(ctx, number)
+		must(t, err)
+		if assert.Len(t, el, 1, "order %v", number) {
+			assert.Equal(t, schema.OrderUploaded, el[0].Type)
+			assert.Equal(t, schema.StatusNew, el[0].Status)
+			assert.True(t, time.Time(at(2)).Equal(time.Time(el[0].Created)))
+		}
+	}
+}
+
+func orderNumbers(ol []schema.Order) (numbers []int64) {
+	for _, o := range ol {
+		numbers = append(numbers, o.Order)
+	}
+	return numbers
+}
+
+func testOrdersPages(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	//orders 4 and 5 are uploaded at the same time, their numbers break the tie
+	for number, seconds := range map[int64]int{1: 10, 2: 20, 3: 30, 4: 40, 5: 40, 6: 60} {
+		saveOrder(t, s, number, "alice", at(seconds))
+	}
+	saveOrder(t, s, 7, "bob", at(15))
+	must(t, updateStatus(s, schema.Order{Order: 2, User: "alice", Status: schema.StatusInvalid}))
+	must(t, updateStatus(s, schema.Order{Order: 4, User: "alice", Status: schema.StatusProcessing}))
+
+	tests := []struct {
+		name  string
+		query schema.ListQuery
+		pages [][]int64
+	}{
+		{name: "ascending", query: schema.ListQuery{Limit: 2}, pages: [][]int64{{1, 2}, {3, 4}, {5, 6}}},
+		{name: "descending", query: schema.ListQuery{Limit: 4, Descending: true}, pages: [][]int64{{6, 5, 4, 3}, {2, 1}}},
+		{name: "statuses", query: schema.ListQuery{Limit: 2, Statuses: []schema.OrderStatus{schema.StatusNew, schema.StatusInvalid}}, pages: [][]int64{{1, 2}, {3, 5}, {6}}},
+		{name: "date range", query: schema.ListQuery{Limit: 10, From: time.Time(at(20)), To: time.Time(at(60))}, pages: [][]int64{{2, 3, 4, 5}}},
+		{name: "no limit", query: schema.ListQuery{}, pages: [][]int64{{1, 2, 3, 4, 5, 6}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query
+			for i, page := range tt.pages {
+				ol, more, err := s.GetOrdersList(ctx, "alice", q)
+				must(t, err)
+				assert.Equal(t, page, orderNumbers(ol), "page %v", i)
+				assert.Equal(t, i < len(tt.pages)-1, more, "page %v", i)
+				if len(ol) == 0 {
+					return
+				}
+				last := ol[len(ol)-1]
+				q.After = &schema.Cursor{Time: time.Time(last.Created), ID: last.Order}
+			}
+		})
+	}
+}
+
+func testNewOrders(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveOrder(t, s, 1, "alice", at(1))
+	saveOrder(t, s, 2, "alice", at(2))
+	saveOrder(t, s, 3, "alice", at(3))
+	must(t, updateStatus(s, schema.Order{Order: 2, User: "alice", Status: schema.StatusProcessing}))
+	must(t, updateStatus(s, schema.Order{Order: 3, User: "alice", Status: schema.StatusInvalid}))
+
+	ol, err := s.GetNewOrdersList(ctx)
+	must(t, err)
+	assert.Len(t, ol, 2)
+	assert.Equal(t, schema.StatusNew, ol[1].Status)
+	assert.Equal(t, schema.StatusProcessing, ol[2].Status)
+}
+
+func testClaimOrders(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveOrder(t, s, 3, "alice", at(3))
+	saveOrder(t, s, 1, "alice", at(1))
+	saveOrder(t, s, 2, "alice", at(2))
+	saveOrder(t, s, 4, "alice", at(4))
+	must(t, updateStatus(s, schema.Order{Order: 4, User: "alice", Status: schema.StatusInvalid}))
+
+	//the oldest orders go first
+	ol, err := s.ClaimOrders(ctx, "a", time.Minute, 2)
+	must(t, err)
+	assert.Len(t, ol, 2)
+	assert.Contains(t, ol, int64(1))
+	assert.Contains(t, ol, int64(2))
+
+	//leased orders and orders in terminal statuses are not claimed
+	ol, err = s.ClaimOrders(ctx, "b", time.Minute, 10)
+	must(t, err)
+	assert.Len(t, ol, 1)
+	assert.Contains(t, ol, int64(3))
+
+	//only the owner releases the lease
+	must(t, s.ReleaseOrder(ctx, 1, "b"))
+	ol, err = s.ClaimOrders(ctx, "b", time.Minute, 10)
+	must(t, err)
+	assert.Empty(t, ol)
+
+	must(t, s.ReleaseOrder(ctx, 1, "a"))
+	ol, err = s.ClaimOrders(ctx, "b", time.Minute, 10)
+	must(t, err)
+	assert.Len(t, ol, 1)
+	assert.Contains(t, ol, int64(1))
+
+	//expired lease of a crashed owner is taken over
+	saveOrder(t, s, 5, "alice", at(5))
+	ol, err = s.ClaimOrders(ctx, "c", time.Millisecond, 10)
+	must(t, err)
+	assert.Len(t, ol, 1)
+	time.Sleep(50 * time.Millisecond)
+	ol, err = s.ClaimOrders(ctx, "d", time.Minute, 10)
+	must(t, err)
+	assert.Len(t, ol, 1)
+	assert.Contains(t, ol, int64(5))
+}
+
+func testPostponedOrders(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveOrder(t, s, 1, "alice", at(1))
+	saveOrder(t, s, 2, "alice", at(2))
+
+	ol, err := s.ClaimOrders(ctx, "a", time.Minute, 1)
+	must(t, err)
+	assert.Contains(t, ol, int64(1))
+	assert.Equal(t, 0, ol[1].PollAttempts)
+
+	//only the owner postpones the order, a postponed order does not hold newer ones
+	must(t, s.PostponeOrder(ctx, 1, "b", 1, time.Now().Add(time.Hour)))
+	must(t, s.PostponeOrder(ctx, 1, "a", 1, time.Now().Add(time.Hour)))
+	must(t, s.ReleaseOrder(ctx, 1, "a"))
+	ol, err = s.ClaimOrders(ctx, "b", time.Minute, 1)
+	must(t, err)
+	assert.Len(t, ol, 1)
+	assert.Contains(t, ol, int64(2))
+	must(t, s.ReleaseOrder(ctx, 2, "b"))
+
+	//the order is not leased to b anymore
+	must(t, s.PostponeOrder(ctx, 2, "b", 2, time.Now().Add(time.Hour)))
+	ol, err = s.ClaimOrders(ctx, "c", time.Minute, 10)
+	must(t, err)
+	assert.Len(t, ol, 1)
+	assert.Equal(t, 0, ol[2].PollAttempts)
+	//the order is due once next passes, its attempts are kept
+	must(t, s.PostponeOrder(ctx, 2, "c", 3, time.Now().Add(-time.Second)))
+	must(t, s.ReleaseOrder(ctx, 2, "c"))
+	ol, err = s.ClaimOrders(ctx, "d", time.Minute, 10)
+	must(t, err)
+	assert.Len(t, ol, 1)
+	assert.Equal(t, 3, ol[2].PollAttempts)
+}
+
+func testOrderStatus(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveOrder(t, s, 1, "alice", at(1))
+
+	assertNotFound(t, updateStatus(s, schema.Order{Order: 2, User: "alice", Status: schema.StatusProcessing}))
+
+	must(t, updateStatus(s, schema.Order{Order: 1, User: "alice", Status: schema.StatusProcessing}))
+	processed := schema.Order{Order: 1, User: "alice", Status: schema.StatusProcessed, Accrual: 72950}
+	must(t, updateStatus(s, processed))
+	//repeated update does not credit the order twice
+	must(t, updateStatus(s, processed))
+
+	got, err := s.GetOrder(ctx, 1)
+	must(t, err)
+	assert.Equal(t, schema.StatusProcessed, got.Status)
+	assert.Equal(t, schema.Points(72950), got.Accrual)
+
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 72950}, *b)
+	u, err := s.GetUser(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Points(72950), u.Accrual)
+
+	err = updateStatus(s, schema.Order{Order: 1, User: "alice", Status: schema.StatusProcessing})
+	assert.True(t, errors.Is(err, schema.ErrIllegalTransition), "got %v", err)
+}
+
+func testOrderEvents(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveOrder(t, s, 1, "alice", at(1))
+
+	assertNotFound(t, s.AddOrderEvent(ctx, schema.OrderEvent{Order: 2, Type: schema.OrderUploaded, Created: at(1)}))
+	_, err := s.GetOrderEvents(ctx, 2)
+	assertNotFound(t, err)
+
+	//the saved order starts its history
+	el, err := s.GetOrderEvents(ctx, 1)
+	must(t, err)
+	if assert.Len(t, el, 1) {
+		assert.Equal(t, schema.OrderUploaded, el[0].Type)
+		assert.Equal(t, schema.StatusNew, el[0].Status)
+	}
+
+	events := []schema.OrderEvent{
+		//repeats the upload event of SaveOrder, so it is skipped
+		{Order: 1, Type: schema.OrderUploaded, Status: schema.StatusNew, Created: at(1)},
+		{Order: 1, Type: schema.OrderPolled, Status: schema.StatusNew, Detail: schema.AccrualNotRegistered, Created: at(2)},
+		{Order: 1, Type: schema.OrderPolled, Status: schema.StatusProcessing, Detail: "PROCESSING", Created: at(3)},
+		//the same poll result again is not a new event
+		{Order: 1, Type: schema.OrderPolled, Status: schema.StatusProcessing, Detail: "PROCESSING", Created: at(4)},
+		{Order: 1, Type: schema.OrderPolled, Status: schema.StatusProcessed, Accrual: 72950, Detail: "PROCESSED", Created: at(5)},
+		{Order: 1, Type: schema.OrderCredited, Status: schema.StatusProcessed, Accrual: 72950, Created: at(5)},
+	}
+	for _, e := range events {
+		must(t, s.AddOrderEvent(ctx, e))
+	}
+
+	el, err = s.GetOrderEvents(ctx, 1)
+	must(t, err)
+	want := append(events[:3:3], events[4:]...)
+	if assert.Len(t, el, len(want)) {
+		for i := range want {
+			assert.Equal(t, want[i].Type, el[i].Type, "event %v", i)
+			assert.Equal(t, want[i].Status, el[i].Status, "event %v", i)
+			assert.Equal(t, want[i].Accrual, el[i].Accrual, "event %v", i)
+			assert.Equal(t, want[i].Detail, el[i].Detail, "event %v", i)
+			assert.True(t, time.Time(want[i].Created).Equal(time.Time(el[i].Created)), "event %v", i)
+		}
+	}
+}
+
+func testLedger(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{}, *b)
+
+	assertNotFound(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "nobody", Type: schema.LedgerAdjustment, Amount: 100, Created: at(0)}))
+
+	accrual := schema.LedgerEntry{User: "alice", Type: schema.LedgerAccrual, Amount: 10000, Order: 1, Created: at(1)}
+	must(t, s.AddLedgerEntry(ctx, accrual))
+	//one entry of a type per order
+	err = s.AddLedgerEntry(ctx, accrual)
+	assert.True(t, errors.Is(err, stor.ErrLedgerEntryExists), "got %v", err)
+
+	must(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "alice", Type: schema.LedgerWithdrawal, Amount: -2550, Order: 2, Created: at(2)}))
+	err = s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "alice", Type: schema.LedgerWithdrawal, Amount: -7451, Order: 3, Created: at(3)})
+	assert.True(t, errors.Is(err, stor.ErrInsufficientFunds), "got %v", err)
+	must(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "alice", Type: schema.LedgerWithdrawal, Amount: -7450, Order: 3, Created: at(3)}))
+
+	b, err = s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 0, Withdrawn: 10000}, *b)
+	u, err := s.GetUser(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Points(0), u.Accrual)
+	assert.Equal(t, schema.Points(10000), u.Withdrawal)
+}
+
+func testWithdrawals(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+	credit(t, s, "alice", 1000)
+	credit(t, s, "bob", 1000)
+
+	wl, _, err := s.GetWithdrawalsList(ctx, "alice", schema.ListQuery{})
+	must(t, err)
+	assert.Empty(t, *wl)
+
+	for i, amount := range []schema.Points{100, 300, 200} {
+		must(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "alice", Type: schema.LedgerWithdrawal, Amount: -amount, Order: int64(i + 1), Created: at(i + 1)}))
+	}
+	must(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "bob", Type: schema.LedgerWithdrawal, Amount: -50, Order: 10, Created: at(10)}))
+
+	//one withdrawal per order, whoever made it
+	_, err = s.Withdraw(ctx, withdrawal("alice", 1, 10), "")
+	assert.True(t, errors.Is(err, stor.ErrLedgerEntryExists), "got %v", err)
+	_, err = s.Withdraw(ctx, withdrawal("bob", 1, 10), "")
+	assert.True(t, errors.Is(err, stor.ErrLedgerEntryExists), "got %v", err)
+
+	//newest first
+	wl, more, err := s.GetWithdrawalsList(ctx, "alice", schema.ListQuery{Descending: true})
+	must(t, err)
+	assert.False(t, more)
+	if assert.Len(t, *wl, 3) {
+		for i, amount := range []schema.Points{200, 300, 100} {
+			assert.Equal(t, int64(3-i), (*wl)[i].Order)
+			assert.Equal(t, "alice", (*wl)[i].User)
+			assert.Equal(t, amount, (*wl)[i].Withdrawal)
+			assert.True(t, time.Time(at(3-i)).Equal(time.Time((*wl)[i].Processed)))
+		}
+	}
+}
+
+func testWithdrawalsPages(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+	credit(t, s, "alice", 1000)
+	credit(t, s, "bob", 1000)
+	for order := int64(1); order <= 5; order++ {
+		_, err := s.Withdraw(ctx, withdrawal("alice", order, 10), "")
+		must(t, err)
+	}
+	_, err := s.Withdraw(ctx, withdrawal("bob", 6, 10), "")
+	must(t, err)
+
+	tests := []struct {
+		name  string
+		query schema.ListQuery
+		pages [][]int64
+	}{
+		{name: "ascending", query: schema.ListQuery{Limit: 2}, pages: [][]int64{{1, 2}, {3, 4}, {5}}},
+		{name: "descending", query: schema.ListQuery{Limit: 3, Descending: true}, pages: [][]int64{{5, 4, 3}, {2, 1}}},
+		{name: "date range", query: schema.ListQuery{Limit: 10, From: time.Time(at(2)), To: time.Time(at(4))}, pages: [][]int64{{2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query
+			for i, page := range tt.pages {
+				wl, more, err := s.GetWithdrawalsList(ctx, "alice", q)
+				must(t, err)
+				var orders []int64
+				for _, w := range *wl {
+					orders = append(orders, w.Order)
+				}
+				assert.Equal(t, page, orders, "page %v", i)
+				assert.Equal(t, i < len(tt.pages)-1, more, "page %v", i)
+				if len(*wl) == 0 {
+					return
+				}
+				last := (*wl)[len(*wl)-1]
+				q.After = &schema.Cursor{Time: time.Time(last.Processed), ID: last.ID}
+			}
+		})
+	}
+}
+
+func withdrawal(user string, order int64, amount schema.Points) schema.LedgerEntry {
+	return schema.LedgerEntry{User: user, Type: schema.LedgerWithdrawal, Amount: -amount, Order: order, Created: at(int(order))}
+}
+
+func testIdempotentWithdrawals(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+	credit(t, s, "alice", 1000)
+	credit(t, s, "bob", 1000)
+
+	_, err := s.Withdraw(ctx, withdrawal("nobody", 1, 100), "key-1")
+	assertNotFound(t, err)
+
+	replayed, err := s.Withdraw(ctx, withdrawal("alice", 1, 300), "key-1")
+	must(t, err)
+	assert.False(t, replayed)
+	//retry is replayed without debiting again
+	replayed, err = s.Withdraw(ctx, withdrawal("alice", 1, 300), "key-1")
+	must(t, err)
+	assert.True(t, replayed)
+	//the key belongs to the user
+	replayed, err = s.Withdraw(ctx, withdrawal("bob", 2, 300), "key-1")
+	must(t, err)
+	assert.False(t, replayed)
+
+	_, err = s.Withdraw(ctx, withdrawal("alice", 3, 200), "key-1")
+	assert.True(t, errors.Is(err, domain.ErrIdempotencyKeyReused), "got %v", err)
+
+	//failed withdrawal is not remembered, the retry is made again
+	_, err = s.Withdraw(ctx, withdrawal("alice", 4, 900), "key-2")
+	assert.True(t, errors.Is(err, stor.ErrInsufficientFunds), "got %v", err)
+	credit(t, s, "alice", 200)
+	replayed, err = s.Withdraw(ctx, withdrawal("alice", 4, 900), "key-2")
+	must(t, err)
+	assert.False(t, replayed)
+
+	//without key every call debits
+	replayed, err = s.Withdraw(ctx, withdrawal("bob", 5, 100), "")
+	must(t, err)
+	assert.False(t, replayed)
+
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 0, Withdrawn: 1200}, *b)
+	b, err = s.GetBalance(ctx, "bob")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 600, Withdrawn: 400}, *b)
+}
+
+func testWebhooks(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+
+	_, err := s.SaveWebhook(ctx, schema.Webhook{User: "nobody", URL: "http://localhost/hook", Created: at(1)})
+	assertNotFound(t, err)
+
+	first, err := s.SaveWebhook(ctx, schema.Webhook{User: "alice", URL: "http://localhost/hook", Created: at(1)})
+	must(t, err)
+	second, err := s.SaveWebhook(ctx, schema.Webhook{User: "alice", URL: "https://partner.example/hook", Created: at(2)})
+	must(t, err)
+	assert.NotEqual(t, first, second)
+	_, err = s.SaveWebhook(ctx, schema.Webhook{User: "alice", URL: "http://localhost/hook", Created: at(3)})
+	assert.True(t, errors.Is(err, stor.ErrWebhookExists), "got %v", err)
+	//the same url is another webhook for another user
+	_, err = s.SaveWebhook(ctx, schema.Webhook{User: "bob", URL: "http://localhost/hook", Created: at(3)})
+	must(t, err)
+
+	wl, err := s.GetWebhooks(ctx, "alice")
+	must(t, err)
+	if assert.Len(t, wl, 2) {
+		assert.Equal(t, first, wl[0].ID)
+		assert.Equal(t, "http://localhost/hook", wl[0].URL)
+		assert.True(t, time.Time(at(1)).Equal(time.Time(wl[0].Created)))
+		assert.Equal(t, second, wl[1].ID)
+	}
+
+	assertNotFound(t, s.DeleteWebhook(ctx, "bob", first))
+	must(t, s.DeleteWebhook(ctx, "alice", first))
+	assertNotFound(t, s.DeleteWebhook(ctx, "alice", first))
+	wl, err = s.GetWebhooks(ctx, "alice")
+	must(t, err)
+	assert.Len(t, wl, 1)
+}
+
+func testWebhookDeliveries(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+	first, err := s.SaveWebhook(ctx, schema.Webhook{User: "alice", URL: "http://localhost/first", Created: at(1)})
+	must(t, err)
+	_, err = s.SaveWebhook(ctx, schema.Webhook{User: "alice", URL: "http://localhost/second", Created: at(1)})
+	must(t, err)
+
+	delivery := schema.WebhookDelivery{User: "alice", Order: 1, Event: "order.processed", Payload: []byte(`{"order":1}`), Created: at(2)}
+	n, err := s.AddWebhookDeliveries(ctx, delivery)
+	must(t, err)
+	assert.Equal(t, 2, n)
+	n, err = s.AddWebhookDeliveries(ctx, schema.WebhookDelivery{User: "bob", Order: 2, Event: "order.invalid", Payload: []byte(`{}`), Created: at(2)})
+	must(t, err)
+	assert.Equal(t, 0, n)
+
+	dl, err := s.ClaimWebhookDeliveries(ctx, "a", time.Minute, 10)
+	must(t, err)
+	if !assert.Len(t, dl, 2) {
+		return
+	}
+	for _, d := range dl {
+		assert.Equal(t, schema.DeliveryPending, d.Status)
+		assert.Equal(t, int64(1), d.Order)
+		assert.Equal(t, "order.processed", d.Event)
+		assert.JSONEq(t, `{"order":1}`, string(d.Payload))
+		assert.Contains(t, []string{"http://localhost/first", "http://localhost/second"}, d.URL)
+	}
+	//leased deliveries are not claimed twice
+	again, err := s.ClaimWebhookDeliveries(ctx, "b", time.Minute, 10)
+	must(t, err)
+	assert.Empty(t, again)
+
+	delivered, retried := dl[0], dl[1]
+	delivered.Status = schema.DeliveryDelivered
+	delivered.Attempts = 1
+	delivered.ResponseStatus = 200
+	assertNotFound(t, s.UpdateWebhookDelivery(ctx, delivered, "b"))
+	must(t, s.UpdateWebhookDelivery(ctx, delivered, "a"))
+	retried.Attempts = 1
+	retried.ResponseStatus = 500
+	retried.LastError = "500 Internal Server Error"
+	retried.NextAttempt = schema.CreatedTime(time.Now().Add(time.Hour).Truncate(time.Second))
+	must(t, s.UpdateWebhookDelivery(ctx, retried, "a"))
+
+	//the retry is not due yet
+	again, err = s.ClaimWebhookDeliveries(ctx, "b", time.Minute, 10)
+	must(t, err)
+	assert.Empty(t, again)
+
+	history, more, err := s.GetWebhookDeliveries(ctx, "alice", schema.ListQuery{Limit: 1})
+	must(t, err)
+	assert.True(t, more)
+	assert.Len(t, history, 1)
+	history, _, err = s.GetWebhookDeliveries(ctx, "alice", schema.ListQuery{})
+	must(t, err)
+	if assert.Len(t, history, 2) {
+		byID := map[int64]schema.WebhookDelivery{history[0].ID: history[0], history[1].ID: history[1]}
+		assert.Equal(t, schema.DeliveryDelivered, byID[delivered.ID].Status)
+		assert.Equal(t, 200, byID[delivered.ID].ResponseStatus)
+		assert.Equal(t, schema.DeliveryPending, byID[retried.ID].Status)
+		assert.Equal(t, 1, byID[retried.ID].Attempts)
+		assert.Equal(t, "500 Internal Server Error", byID[retried.ID].LastError)
+		assert.True(t, time.Time(retried.NextAttempt).Equal(time.Time(byID[retried.ID].NextAttempt)))
+	}
+	history, _, err = s.GetWebhookDeliveries(ctx, "bob", schema.ListQuery{})
+	must(t, err)
+	assert.Empty(t, history)
+
+	//deliveries go away with their webhook
+	must(t, s.DeleteWebhook(ctx, "alice", first))
+	history, _, err = s.GetWebhookDeliveries(ctx, "alice", schema.ListQuery{})
+	must(t, err)
+	assert.Len(t, history, 1)
+}
+
+func testPointsExpiry(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+	accrual := func(user string, order int64, amount schema.Points, expires schema.CreatedTime) {
+		t.Helper()
+		must(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{
+			User: user, Type: schema.LedgerAccrual, Amount: amount, Order: order, Created: at(int(order)), Expires: expires,
+		}))
+	}
+	credit(t, s, "alice", 100)
+	accrual("alice", 2, 300, at(200))
+	accrual("alice", 1, 500, at(100))
+	//debits are first in first out: the credit that never expires and the one of order 2 are spent,
+	//though order 1 expires earlier
+	must(t, s.AddLedgerEntry(ctx, withdrawal("alice", 3, 600)))
+
+	el, err := s.GetExpiringPoints(ctx, "alice", time.Time(at(1000)))
+	must(t, err)
+	assert.Len(t, el, 1)
+	for _, p := range el {
+		assert.Equal(t, int64(1), p.Order)
+		assert.Equal(t, schema.Points(300), p.Amount)
+		assert.True(t, time.Time(at(100)).Equal(time.Time(p.Expires)))
+	}
+	el, err = s.GetExpiringPoints(ctx, "alice", time.Time(at(100)))
+	must(t, err)
+	assert.Empty(t, el)
+
+	//nothing is due yet
+	n, err := s.ExpirePoints(ctx, time.Time(at(50)), 10)
+	must(t, err)
+	assert.Equal(t, 0, n)
+	//spent points of order 2 are not written off
+	n, err = s.ExpirePoints(ctx, time.Time(at(200)), 10)
+	must(t, err)
+	assert.Equal(t, 1, n)
+	n, err = s.ExpirePoints(ctx, time.Time(at(200)), 10)
+	must(t, err)
+	assert.Equal(t, 0, n)
+
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 0, Withdrawn: 600}, *b)
+
+	//the expiration writes off its own credit, not the oldest one
+	credit(t, s, "alice", 50)
+	accrual("alice", 6, 70, at(300))
+	n, err = s.ExpirePoints(ctx, time.Time(at(300)), 10)
+	must(t, err)
+	assert.Equal(t, 1, n)
+	must(t, s.AddLedgerEntry(ctx, withdrawal("alice", 7, 50)))
+	b, err = s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 0, Withdrawn: 650}, *b)
+	el, err = s.GetExpiringPoints(ctx, "alice", time.Time(at(1000)))
+	must(t, err)
+	assert.Empty(t, el)
+
+	//accrual of the processed order expires as the order tells
+	saveOrder(t, s, 4, "bob", at(4))
+	must(t, updateStatus(s, schema.Order{Order: 4, User: "bob", Status: schema.StatusProcessed, Accrual: 700, AccrualExpires: at(300)}))
+	el, err = s.GetExpiringPoints(ctx, "bob", time.Time(at(1000)))
+	must(t, err)
+	if assert.Len(t, el, 1) {
+		assert.Equal(t, schema.ExpiringPoints{Order: 4, Amount: 700, Expires: el[0].Expires}, el[0])
+		assert.True(t, time.Time(at(300)).Equal(time.Time(el[0].Expires)))
+	}
+	n, err = s.ExpirePoints(ctx, time.Time(at(1000)), 10)
+	must(t, err)
+	assert.Equal(t, 1, n)
+	b, err = s.GetBalance(ctx, "bob")
+	must(t, err)
+	assert.Equal(t, schema.Balance{}, *b)
+}
+
+func testTiers(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+	accrual := func(user string, order int64, amount schema.Points) {
+		t.Helper()
+		must(t, s.AddLedgerEntry(ctx, schema.LedgerEntry{
+			User: user, Type: schema.LedgerAccrual, Amount: amount, Order: order, Created: at(int(order)),
+		}))
+	}
+	accrual("alice", 10, 300)
+	accrual("alice", 20, 500)
+	//only accruals are counted
+	credit(t, s, "alice", 1000)
+	must(t, s.AddLedgerEntry(ctx, withdrawal("alice", 30, 200)))
+
+	p, err := s.GetAccrued(ctx, "alice", time.Time(at(10)))
+	must(t, err)
+	assert.Equal(t, schema.Points(800), p)
+	p, err = s.GetAccrued(ctx, "alice", time.Time(at(11)))
+	must(t, err)
+	assert.Equal(t, schema.Points(500), p)
+
+	tier, err := s.GetUserTier(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, "", tier)
+	_, err = s.GetUserTier(ctx, "nobody")
+	assertNotFound(t, err)
+	_, err = s.SetUserTier(ctx, schema.TierChange{User: "nobody", To: "Silver", Created: at(0)})
+	assertNotFound(t, err)
+
+	changed, err := s.SetUserTier(ctx, schema.TierChange{User: "alice", To: "Silver", Accrued: 800, Created: at(40)})
+	must(t, err)
+	assert.True(t, changed)
+	//the same tier is not a change
+	changed, err = s.SetUserTier(ctx, schema.TierChange{User: "alice", To: "Silver", Accrued: 900, Created: at(50)})
+	must(t, err)
+	assert.False(t, changed)
+	changed, err = s.SetUserTier(ctx, schema.TierChange{User: "alice", To: "Gold", Accrued: 5000, Created: at(60)})
+	must(t, err)
+	assert.True(t, changed)
+
+	tier, err = s.GetUserTier(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, "Gold", tier)
+	cl, err := s.GetTierChanges(ctx, "alice")
+	must(t, err)
+	if assert.Len(t, cl, 2) {
+		assert.Equal(t, []string{"", "Silver"}, []string{cl[0].From, cl[0].To})
+		assert.Equal(t, schema.Points(800), cl[0].Accrued)
+		assert.True(t, time.Time(at(40)).Equal(time.Time(cl[0].Created)))
+		assert.Equal(t, []string{"Silver", "Gold"}, []string{cl[1].From, cl[1].To})
+		assert.Equal(t, "alice", cl[1].User)
+	}
+	cl, err = s.GetTierChanges(ctx, "bob")
+	must(t, err)
+	assert.Empty(t, cl)
+}
+
+func testOrderStatusTiers(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	tiers := schema.Tiers{{Name: "Silver", Threshold: 1000, Multiplier: 1.5}}
+	credited := func(order int64, accrual schema.Points) schema.Points {
+		t.Helper()
+		saveOrder(t, s, order, "alice", at(int(order)))
+		p, err := s.UpdateOrderStatus(ctx, schema.Order{Order: order, User: "alice", Status: schema.StatusProcessed, Accrual: accrual}, tiers)
+		must(t, err)
+		return p
+	}
+	assert.Equal(t, schema.Points(800), credited(1, 800))
+	//the credit reaching the threshold is not multiplied yet
+	assert.Equal(t, schema.Points(400), credited(2, 400))
+	assert.Equal(t, schema.Points(150), credited(3, 100))
+	//repeated update credits nothing
+	p, err := s.UpdateOrderStatus(ctx, schema.Order{Order: 3, User: "alice", Status: schema.StatusProcessed, Accrual: 100}, tiers)
+	must(t, err)
+	assert.Equal(t, schema.Points(0), p)
+
+	got, err := s.GetOrder(ctx, 3)
+	must(t, err)
+	assert.Equal(t, schema.Points(150), got.Accrual)
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 1350}, *b)
+	tier, err := s.GetUserTier(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, "Silver", tier)
+	cl, err := s.GetTierChanges(ctx, "alice")
+	must(t, err)
+	if assert.Len(t, cl, 1) {
+		assert.Equal(t, []string{"", "Silver"}, []string{cl[0].From, cl[0].To})
+		assert.Equal(t, schema.Points(1200), cl[0].Accrued)
+	}
+}
+
+func testOutbox(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	saveUser(t, s, "bob")
+	saveOrder(t, s, 1, "alice", at(1))
+	saveOrder(t, s, 1, "alice", at(1))
+	saveOrder(t, s, 2, "bob", at(2))
+	must(t, updateStatus(s, schema.Order{Order: 1, User: "alice", Status: schema.StatusProcessed, Accrual: 500}))
+	must(t, updateStatus(s, schema.Order{Order: 1, User: "alice", Status: schema.StatusProcessed, Accrual: 500}))
+	//failed changes leave no messages
+	assert.Error(t, s.AddLedgerEntry(ctx, withdrawal("bob", 3, 100)))
+
+	ml, err := s.ClaimOutbox(ctx, "a", time.Minute, 10)
+	must(t, err)
+	type message struct {
+		user  string
+		topic schema.OutboxTopic
+		order int64
+	}
+	var got []message
+	for _, m := range ml {
+		got = append(got, message{m.User, m.Topic, m.Order})
+	}
+	assert.Equal(t, []message{
+		{"alice", schema.TopicOrderUploaded, 1},
+		{"bob", schema.TopicOrderUploaded, 2},
+		{"alice", schema.TopicLedgerEntry, 1},
+		{"alice", schema.TopicOrderStatus, 1},
+	}, got)
+	if len(ml) != 4 {
+		return
+	}
+	var order schema.Order
+	must(t, json.Unmarshal(ml[3].Payload, &order))
+	assert.Equal(t, schema.StatusProcessed, order.Status)
+	assert.Equal(t, schema.Points(500), order.Accrual)
+	var entry schema.LedgerEntry
+	must(t, json.Unmarshal(ml[2].Payload, &entry))
+	assert.Equal(t, schema.LedgerAccrual, entry.Type)
+	assert.Equal(t, schema.Points(500), entry.Amount)
+
+	//leased messages are not claimed twice
+	again, err := s.ClaimOutbox(ctx, "b", time.Minute, 10)
+	must(t, err)
+	assert.Empty(t, again)
+
+	assertNotFound(t, s.AckOutboxMessage(ctx, ml[0].ID, "b"))
+	must(t, s.AckOutboxMessage(ctx, ml[0].ID, "a"))
+	must(t, s.AckOutboxMessage(ctx, ml[1].ID, "a"))
+	failed := ml[2]
+	failed.Attempts = 1
+	failed.LastError = "sink is down"
+	failed.NextAttempt = schema.CreatedTime(time.Now().Add(time.Hour))
+	assertNotFound(t, s.RetryOutboxMessage(ctx, failed, "b"))
+	must(t, s.RetryOutboxMessage(ctx, failed, "a"))
+
+	//the status change of alice waits behind the failed message
+	again, err = s.ClaimOutbox(ctx, "b", time.Minute, 10)
+	must(t, err)
+	assert.Empty(t, again)
+
+	//later changes of other users are not held
+	saveOrder(t, s, 4, "bob", at(4))
+	again, err = s.ClaimOutbox(ctx, "b", time.Minute, 10)
+	must(t, err)
+	if assert.Len(t, again, 1) {
+		assert.Equal(t, "bob", again[0].User)
+		assert.Equal(t, int64(4), again[0].Order)
+	}
+
+	//the retry released the lease
+	assertNotFound(t, s.AckOutboxMessage(ctx, failed.ID, "a"))
+}
+
+func testRevokedTokens(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+
+	revoked, err := s.IsTokenRevoked(ctx, "jti-1")
+	must(t, err)
+	assert.False(t, revoked)
+
+	expires := time.Now().Add(time.Hour)
+	must(t, s.RevokeToken(ctx, "jti-1", expires))
+	//repeated logout is not an error
+	must(t, s.RevokeToken(ctx, "jti-1", expires))
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-1")
+	must(t, err)
+	assert.True(t, revoked)
+	revoked, err = s.IsTokenRevoked(ctx, "jti-2")
+	must(t, err)
+	assert.False(t, revoked)
+}
+
+func testConcurrentWithdrawals(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	credit(t, s, "alice", 1000)
+
+	//ten withdrawals of 200 race for 1000, exactly five of them fit
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		succeeded    int
+		insufficient int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.AddLedgerEntry(ctx, schema.LedgerEntry{User: "alice", Type: schema.LedgerWithdrawal, Amount: -200, Order: int64(i + 1), Created: at(i)})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, stor.ErrInsufficientFunds):
+				insufficient++
+			default:
+				t.Errorf("withdrawal %v: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 5, succeeded)
+	assert.Equal(t, 5, insufficient)
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 0, Withdrawn: 1000}, *b)
+}
+
+func testConcurrentCredits(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	tiers := schema.Tiers{{Name: "Silver", Threshold: 1000, Multiplier: 2}}
+	for i := 1; i <= 12; i++ {
+		saveOrder(t, s, int64(i), "alice", at(i))
+	}
+
+	//credits of the user are serialized: ten of 100 reach the threshold, the other two are doubled
+	var wg sync.WaitGroup
+	for i := 1; i <= 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.UpdateOrderStatus(ctx, schema.Order{Order: int64(i), User: "alice", Status: schema.StatusProcessed, Accrual: 100}, tiers)
+			if err != nil {
+				t.Errorf("credit %v: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 1400}, *b)
+	cl, err := s.GetTierChanges(ctx, "alice")
+	must(t, err)
+	assert.Len(t, cl, 1)
+}
+
+func testConcurrentUploads(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	users := []string{"alice", "bob", "carol", "dave"}
+	var batch []schema.Order
+	for i := int64(1); i <= 50; i++ {
+		batch = append(batch, schema.Order{Order: i, Status: schema.StatusNew, Created: at(int(i))})
+	}
+
+	//users race for the same batch, every number is saved for one of them
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		taken int
+	)
+	for _, user := range users {
+		saveUser(t, s, user)
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			ol := make([]schema.Order, len(batch))
+			for i, o := range batch {
+				o.User = user
+				ol[i] = o
+			}
+			numbers, err := s.SaveOrders(ctx, ol)
+			if err != nil {
+				t.Errorf("user %v: %v", user, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			taken += len(numbers)
+		}(user)
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(batch)*(len(users)-1), taken)
+	saved := 0
+	for _, user := range users {
+		ol, _, err := s.GetOrdersList(ctx, user, schema.ListQuery{})
+		must(t, err)
+		saved += len(ol)
+	}
+	assert.Equal(t, len(batch), saved)
+}
+
+func testConcurrentClaims(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	for i := 1; i <= 20; i++ {
+		saveOrder(t, s, int64(i), "alice", at(i))
+	}
+
+	//every order is leased to exactly one of the owners
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed = make(map[int64]string)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			for {
+				ol, err := s.ClaimOrders(ctx, owner, time.Minute, 3)
+				if err != nil {
+					t.Errorf("claim by %v: %v", owner, err)
+					return
+				}
+				if len(ol) == 0 {
+					return
+				}
+				mu.Lock()
+				for number := range ol {
+					if previous, ok := claimed[number]; ok {
+						t.Errorf("order %v claimed by %v and %v", number, previous, owner)
+					}
+					claimed[number] = owner
+				}
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("owner-%v", i))
+	}
+	wg.Wait()
+
+	assert.Len(t, claimed, 20)
+}
+
+func testConcurrentRetries(t *testing.T, s stor.Storage) {
+	ctx := context.Background()
+	saveUser(t, s, "alice")
+	credit(t, s, "alice", 1000)
+
+	//retries of one withdrawal racing with each other debit once
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		debited  int
+		replayed int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := s.Withdraw(ctx, withdrawal("alice", 1, 300), "key-1")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				t.Errorf("withdrawal: %v", err)
+			case r:
+				replayed++
+			default:
+				debited++
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, debited)
+	assert.Equal(t, 9, replayed)
+	b, err := s.GetBalance(ctx, "alice")
+	must(t, err)
+	assert.Equal(t, schema.Balance{Current: 700, Withdrawn: 300}, *b)
+}
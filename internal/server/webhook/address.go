@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+)
+
+// PublicIP tells whether deliveries may go to ip, loopback, private, link-local,
+// multicast and unspecified addresses belong to the server's own network
+func PublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// CheckURL refuses webhook urls with internal addresses, host names are checked again
+// when a delivery connects, as they may resolve to another address by then
+func CheckURL(u *url.URL) error {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %v", domain.ErrWebhookNotPublic, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicIP(ip) {
+		return fmt.Errorf("%w: %v", domain.ErrWebhookNotPublic, host)
+	}
+	return nil
+}
+
+// dialControl refuses connections to internal addresses whatever the host name resolved to
+func dialControl(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return fmt.Errorf("%w: %v", domain.ErrWebhookNotPublic, host)
+	}
+	return nil
+}
+
+// newClient connects to public addresses only and does not follow redirects,
+// so that a webhook cannot send deliveries into the server's network
+func newClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: dialControl}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
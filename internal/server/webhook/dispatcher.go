@@ -0,0 +1,261 @@
+// Package webhook tells partners about orders reaching a final status.
+// Dispatcher is an outbox sink queuing deliveries of order status changes, deliveries are kept in storage,
+// so they survive restarts, and are retried with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/alphaonly/gomartv2/internal/schema"
+	storage "github.com/alphaonly/gomartv2/internal/server/storage/interfaces"
+)
+
+const (
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+	TimestampHeader = "X-Gophermart-Timestamp"
+	// SignatureHeader is "sha256=" and hex HMAC-SHA256 of timestamp, "." and body with the webhook secret
+	SignatureHeader = "X-Gophermart-Signature"
+)
+
+// Event is the payload of a delivery
+type Event struct {
+	//ID is the same in every delivery of the order change, partners deduplicate events by it
+	ID      int64              `json:"id"`
+	Event   string             `json:"event"`
+	Order   schema.Order       `json:"order"`
+	Created schema.CreatedTime `json:"created_at"`
+}
+
+// EventName is the event of the order status, only final statuses are sent to webhooks
+func EventName(status schema.OrderStatus) (event string, ok bool) {
+	switch status {
+	case schema.StatusProcessed:
+		return "order.processed", true
+	case schema.StatusInvalid:
+		return "order.invalid", true
+	}
+	return "", false
+}
+
+type Dispatcher struct {
+	storage     storage.Storage
+	key         []byte
+	client      *http.Client
+	owner       string
+	interval    time.Duration
+	lease       time.Duration
+	batch       int
+	minRetry    time.Duration
+	maxRetry    time.Duration
+	maxAttempts int
+}
+
+// NewDispatcher creates dispatcher signing deliveries with secrets derived from key
+func NewDispatcher(storage storage.Storage, key string) *Dispatcher {
+	k := []byte(key)
+	if len(k) == 0 {
+		//no key configured: webhook secrets change with every restart
+		k = make([]byte, 32)
+		if _, err := rand.Read(k); err != nil {
+			log.Fatal("cannot generate webhook signing key")
+		}
+		log.Println("webhook: KEY is empty, random webhook signing key generated")
+	}
+	return &Dispatcher{
+		storage:     storage,
+		key:         k,
+		client:      newClient(),
+		owner:       newOwnerID(),
+		interval:    time.Second,
+		lease:       time.Minute,
+		batch:       20,
+		minRetry:    10 * time.Second,
+		maxRetry:    time.Hour,
+		maxAttempts: 10,
+	}
+}
+
+// newOwnerID identifies the dispatcher of this instance in delivery leases
+func newOwnerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal("cannot generate webhook dispatcher id")
+	}
+	return fmt.Sprintf("%v-%v-%v", host, os.Getpid(), hex.EncodeToString(id))
+}
+
+// Secret is the signing secret of the webhook, it is derived from the server key and is not stored
+func (d *Dispatcher) Secret(webhookID int64) string {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write([]byte("webhook:" + strconv.FormatInt(webhookID, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns SignatureHeader value of the body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send queues deliveries of the order status change message to the webhooks of its user,
+// other messages and not final statuses are skipped
+func (d *Dispatcher) Send(ctx context.Context, m schema.OutboxMessage) error {
+	if m.Topic != schema.TopicOrderStatus {
+		return nil
+	}
+	var o schema.Order
+	if err := json.Unmarshal(m.Payload, &o); err != nil {
+		return fmt.Errorf("outbox message %v is not an order: %w", m.ID, err)
+	}
+	event, ok := EventName(o.Status)
+	if !ok {
+		return nil
+	}
+	payload, err := json.Marshal(Event{ID: m.ID, Event: event, Order: o, Created: m.Created})
+	if err != nil {
+		return err
+	}
+	_, err = d.storage.AddWebhookDeliveries(ctx, schema.WebhookDelivery{
+		User:    m.User,
+		Order:   o.Order,
+		Event:   event,
+		Payload: payload,
+		Created: schema.CreatedTime(time.Now()),
+	})
+	return err
+}
+
+// Run delivers due deliveries until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.dispatch(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	dl, err := d.storage.ClaimWebhookDeliveries(ctx, d.owner, d.lease, d.batch)
+	if err != nil {
+		log.Printf("can not claim webhook deliveries: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range dl {
+		wg.Add(1)
+		go func(delivery schema.WebhookDelivery) {
+			defer wg.Done()
+			d.deliver(ctx, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery schema.WebhookDelivery) {
+	status, err := d.send(ctx, delivery)
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = schema.DeliveryDelivered
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = schema.DeliveryFailed
+		delivery.LastError = deliveryError(status, err)
+	default:
+		delivery.LastError = deliveryError(status, err)
+		delivery.NextAttempt = schema.CreatedTime(time.Now().Add(d.retryAfter(delivery.Attempts)))
+	}
+	if err != nil {
+		log.Printf("webhook delivery %v attempt %v failed: %v", delivery.ID, delivery.Attempts, err)
+	}
+	//the outcome is saved even if dispatcher is stopping, otherwise the attempt is repeated after the lease
+	saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.storage.UpdateWebhookDelivery(saveCtx, delivery, d.owner); err != nil {
+		log.Printf("unable to save webhook delivery %v: %v", delivery.ID, err)
+	}
+}
+
+// send posts the payload, any response but 2xx is a failure
+func (d *Dispatcher) send(ctx context.Context, delivery schema.WebhookDelivery) (status int, err error) {
+	timestamp := time.Now().Unix()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, delivery.Event)
+	request.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(d.Secret(delivery.Webhook), timestamp, delivery.Payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	//the body is drained so that the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook responded %v", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// deliveryError is the failure as the user sees it in the delivery log,
+// transport errors are only logged as they tell about the server's network
+func deliveryError(status int, err error) string {
+	var netErr net.Error
+	switch {
+	case status != 0:
+		return err.Error()
+	case errors.Is(err, domain.ErrWebhookNotPublic):
+		return domain.ErrWebhookNotPublic.Error()
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "webhook did not respond in time"
+	default:
+		return "webhook is unreachable"
+	}
+}
+
+// retryAfter doubles the pause with every failed attempt
+func (d *Dispatcher) retryAfter(attempts int) time.Duration {
+	pause := d.minRetry
+	for i := 1; i < attempts && pause < d.maxRetry; i++ {
+		pause *= 2
+	}
+	if pause > d.maxRetry {
+		pause = d.maxRetry
+	}
+	return pause
+}
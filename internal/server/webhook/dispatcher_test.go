@@ -0,0 +1,195 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alphaonly/gomartv2/internal/domain"
+	"github.com/alphaonly/gomartv2/internal/schema"
+	mem "github.com/alphaonly/gomartv2/internal/server/storage/implementations/memstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func statusMessage(t *testing.T, id int64, o schema.Order) schema.OutboxMessage {
+	m, err := schema.NewOutboxMessage(o.User, schema.TopicOrderStatus, o.Order, o, schema.CreatedTime(time.Now()))
+	assert.NoError(t, err)
+	m.ID = id
+	return m
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+
+	d := NewDispatcher(s, "secret")
+	d.minRetry = 0
+
+	statuses := []int{http.StatusInternalServerError, http.StatusOK}
+	var bodies [][]byte
+	var signatures []bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		bodies = append(bodies, body)
+		signatures = append(signatures, r.Header.Get(SignatureHeader) == Sign(d.Secret(1), timestamp, body))
+		w.WriteHeader(statuses[len(bodies)-1])
+	}))
+	defer ts.Close()
+	//the test server is on loopback, which the dispatcher client refuses
+	d.client = ts.Client()
+
+	id, err := s.SaveWebhook(ctx, schema.Webhook{User: "user1", URL: ts.URL, Created: schema.CreatedTime(time.Now())})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+
+	//not final statuses and other topics are not sent
+	assert.NoError(t, d.Send(ctx, statusMessage(t, 1, schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusProcessing})))
+	uploaded := statusMessage(t, 2, schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusNew})
+	uploaded.Topic = schema.TopicOrderUploaded
+	assert.NoError(t, d.Send(ctx, uploaded))
+	d.dispatch(ctx)
+	assert.Empty(t, bodies)
+
+	assert.NoError(t, d.Send(ctx, statusMessage(t, 3, schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusProcessed, Accrual: 72998})))
+	d.dispatch(ctx)
+	d.dispatch(ctx)
+	d.dispatch(ctx)
+
+	if assert.Len(t, bodies, 2) {
+		assert.Equal(t, bodies[0], bodies[1], "retry sends the same payload")
+		assert.Equal(t, []bool{true, true}, signatures)
+		assert.Contains(t, string(bodies[0]), `"event":"order.processed"`)
+		assert.Contains(t, string(bodies[0]), `"id":3`)
+	}
+	dl, _, err := s.GetWebhookDeliveries(ctx, "user1", schema.ListQuery{})
+	assert.NoError(t, err)
+	if assert.Len(t, dl, 1) {
+		assert.Equal(t, schema.DeliveryDelivered, dl[0].Status)
+		assert.Equal(t, 2, dl[0].Attempts)
+		assert.Equal(t, http.StatusOK, dl[0].ResponseStatus)
+		assert.Empty(t, dl[0].LastError)
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+
+	d := NewDispatcher(s, "secret")
+	d.minRetry = 0
+	d.maxAttempts = 3
+
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	d.client = ts.Client()
+
+	_, err := s.SaveWebhook(ctx, schema.Webhook{User: "user1", URL: ts.URL, Created: schema.CreatedTime(time.Now())})
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.Send(ctx, statusMessage(t, 1, schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusInvalid})))
+	for i := 0; i < 5; i++ {
+		d.dispatch(ctx)
+	}
+	assert.Equal(t, 3, attempts)
+
+	dl, _, err := s.GetWebhookDeliveries(ctx, "user1", schema.ListQuery{})
+	assert.NoError(t, err)
+	if assert.Len(t, dl, 1) {
+		assert.Equal(t, schema.DeliveryFailed, dl[0].Status)
+		assert.Equal(t, "order.invalid", dl[0].Event)
+		assert.Equal(t, http.StatusServiceUnavailable, dl[0].ResponseStatus)
+		assert.NotEmpty(t, dl[0].LastError)
+	}
+}
+
+func TestDispatcherRefusesInternalAddresses(t *testing.T) {
+	ctx := context.Background()
+	s := mem.NewMemStorage()
+	assert.NoError(t, s.SaveUser(ctx, &schema.User{User: "user1", Password: "password1"}))
+
+	d := NewDispatcher(s, "secret")
+	d.minRetry = 0
+	d.maxAttempts = 1
+
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer ts.Close()
+
+	//saved without CreateWebhook checks, as a host name resolving to loopback would be, the connection is refused
+	_, err := s.SaveWebhook(ctx, schema.Webhook{User: "user1", URL: ts.URL, Created: schema.CreatedTime(time.Now())})
+	assert.NoError(t, err)
+	assert.NoError(t, d.Send(ctx, statusMessage(t, 1, schema.Order{Order: 12345678903, User: "user1", Status: schema.StatusInvalid})))
+	d.dispatch(ctx)
+	assert.False(t, called)
+
+	dl, _, err := s.GetWebhookDeliveries(ctx, "user1", schema.ListQuery{})
+	assert.NoError(t, err)
+	if assert.Len(t, dl, 1) {
+		assert.Equal(t, schema.DeliveryFailed, dl[0].Status)
+		assert.Equal(t, 0, dl[0].ResponseStatus)
+		//the log does not tell about the server's network
+		assert.Equal(t, domain.ErrWebhookNotPublic.Error(), dl[0].LastError)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		ok   bool
+	}{
+		{name: "test#1 - Positive: public host name", url: "https://partner.example/hooks", ok: true},
+		{name: "test#2 - Positive: public address", url: "http://8.8.8.8/hooks", ok: true},
+		{name: "test#3 - Negative: loopback", url: "http://127.0.0.1:8080/hooks"},
+		{name: "test#4 - Negative: localhost", url: "http://localhost./hooks"},
+		{name: "test#5 - Negative: private network", url: "http://10.1.2.3/hooks"},
+		{name: "test#6 - Negative: cloud metadata", url: "http://169.254.169.254/latest/meta-data"},
+		{name: "test#7 - Negative: ipv6 loopback", url: "http://[::1]/hooks"},
+		{name: "test#8 - Negative: unspecified", url: "http://0.0.0.0/hooks"},
+		{name: "test#9 - Negative: ipv4 mapped private address", url: "http://[::ffff:192.168.0.1]/hooks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			assert.NoError(t, err)
+			err = CheckURL(u)
+			if tt.ok {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, domain.ErrWebhookNotPublic)
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	d := &Dispatcher{minRetry: 10 * time.Second, maxRetry: time.Minute}
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{name: "test#1 - Positive: first retry", attempts: 1, want: 10 * time.Second},
+		{name: "test#2 - Positive: doubled", attempts: 3, want: 40 * time.Second},
+		{name: "test#3 - Positive: limited", attempts: 10, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, d.retryAfter(tt.attempts))
+		})
+	}
+}